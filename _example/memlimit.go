@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hhq163/golua/lua"
+)
+
+func main() {
+	// the lua heap of this state can't grow past 1MB
+	L := lua.NewStateLimit(1 << 20)
+	defer L.Close()
+	L.OpenLibs()
+
+	err := L.DoString("local t = {} for i = 1, 1e6 do t[i] = i end")
+	fmt.Printf("error: %v\n", err)
+
+	stats, _ := L.MemStats()
+	fmt.Printf("current: %d peak: %d allocations: %d\n", stats.Current, stats.Peak, stats.Allocations)
+}
@@ -5,7 +5,7 @@ import "github.com/hhq163/golua/lua"
 func adder(L *lua.State) int {
 	a := L.ToInteger(1)
 	b := L.ToInteger(2)
-	L.PushInteger(int64(a + b))
+	L.PushInteger(a + b)
 	return 1
 }
 
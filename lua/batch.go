@@ -0,0 +1,129 @@
+package lua
+
+//#include <lua.h>
+//#include <lauxlib.h>
+//#include <stdlib.h>
+//#include "golua.h"
+import "C"
+
+import "unsafe"
+
+// Pushes a new array table containing the elements of v
+func (L *State) PushFloat64s(v []float64) {
+	var p *C.lua_Number
+	if len(v) > 0 {
+		p = (*C.lua_Number)(unsafe.Pointer(&v[0]))
+	}
+	C.clua_pushnumbers(L.state(), p, C.size_t(len(v)))
+}
+
+// Pushes a new array table containing the elements of v as integers
+func (L *State) PushInt64s(v []int64) {
+	var p *C.lua_Integer
+	if len(v) > 0 {
+		p = (*C.lua_Integer)(unsafe.Pointer(&v[0]))
+	}
+	C.clua_pushintegers(L.state(), p, C.size_t(len(v)))
+}
+
+// Pushes a new array table containing the elements of v
+func (L *State) PushStrings(v []string) {
+	size := 0
+	for _, s := range v {
+		size += len(s)
+	}
+	buf := make([]byte, 0, size)
+	lens := make([]C.size_t, len(v)+1)
+	for i, s := range v {
+		buf = append(buf, s...)
+		lens[i] = C.size_t(len(s))
+	}
+	C.clua_pushstrings(L.state(), bufData(buf), &lens[0], C.size_t(len(v)))
+}
+
+// Pushes a new table containing the keys and values of m
+func (L *State) PushStringMap(m map[string]string) {
+	size := 0
+	for k, v := range m {
+		size += len(k) + len(v)
+	}
+	buf := make([]byte, 0, size)
+	lens := make([]C.size_t, 0, 2*len(m)+1)
+	for k, v := range m {
+		buf = append(buf, k...)
+		buf = append(buf, v...)
+		lens = append(lens, C.size_t(len(k)), C.size_t(len(v)))
+	}
+	lens = append(lens, 0)
+	C.clua_pushstringmap(L.state(), bufData(buf), &lens[0], C.size_t(len(m)))
+}
+
+// Returns the address of the contents of buf, nil if it is empty
+func bufData(buf []byte) *C.char {
+	if len(buf) == 0 {
+		return nil
+	}
+	return (*C.char)(unsafe.Pointer(&buf[0]))
+}
+
+// Returns the elements 1 to #t of the array table t at index, converted to numbers.
+// ok is false if an element isn't a number (or a string convertible to a number), in which case the elements before it are returned.
+// ok is false and v is nil if the value at index is not a table.
+func (L *State) ToFloat64s(index int) (v []float64, ok bool) {
+	if !L.IsTable(index) {
+		return nil, false
+	}
+	n := L.ObjLen(index)
+	if n == 0 {
+		return []float64{}, true
+	}
+	v = make([]float64, n)
+	if bad := C.clua_tonumbers(L.state(), C.int(index), (*C.lua_Number)(unsafe.Pointer(&v[0])), C.size_t(n)); bad != 0 {
+		return v[:bad-1], false
+	}
+	return v, true
+}
+
+// Like ToFloat64s for integers, elements that are floats without an exact integer representation are rejected.
+func (L *State) ToInt64s(index int) (v []int64, ok bool) {
+	if !L.IsTable(index) {
+		return nil, false
+	}
+	n := L.ObjLen(index)
+	if n == 0 {
+		return []int64{}, true
+	}
+	v = make([]int64, n)
+	if bad := C.clua_tointegers(L.state(), C.int(index), (*C.lua_Integer)(unsafe.Pointer(&v[0])), C.size_t(n)); bad != 0 {
+		return v[:bad-1], false
+	}
+	return v, true
+}
+
+// Like ToFloat64s for strings, numbers are converted to strings.
+func (L *State) ToStrings(index int) (v []string, ok bool) {
+	if !L.IsTable(index) {
+		return nil, false
+	}
+	n := L.ObjLen(index)
+	if n == 0 {
+		return []string{}, true
+	}
+	lens := make([]C.size_t, n)
+	var total C.size_t
+	ok = true
+	if bad := C.clua_stringlens(L.state(), C.int(index), &lens[0], C.size_t(n), &total); bad != 0 {
+		n = uint(bad - 1)
+		ok = false
+	}
+	buf := make([]byte, total+1)
+	C.clua_copystrings(L.state(), C.int(index), (*C.char)(unsafe.Pointer(&buf[0])), C.size_t(n))
+	// the strings share the buffer, that is never modified afterwards
+	all := unsafe.String(&buf[0], int(total))
+	v = make([]string, n)
+	for i := range v {
+		v[i] = all[:lens[i]]
+		all = all[lens[i]:]
+	}
+	return v, ok
+}
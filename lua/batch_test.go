@@ -0,0 +1,93 @@
+package lua
+
+import "testing"
+
+func TestBatch(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	L.PushFloat64s([]float64{1.5, 2.5})
+	L.SetGlobal("floats")
+	L.PushInt64s([]int64{1, 2, 3})
+	L.SetGlobal("ints")
+	L.PushStrings([]string{"a", "", "bc"})
+	L.SetGlobal("strings")
+	L.PushStringMap(map[string]string{"k": "v", "empty": ""})
+	L.SetGlobal("map")
+	L.PushStrings(nil)
+	L.SetGlobal("none")
+
+	err := L.DoString(`
+		assert(#floats == 2 and floats[2] == 2.5)
+		assert(#ints == 3 and math.type(ints[3]) == "integer")
+		assert(#strings == 3 and strings[2] == "" and strings[3] == "bc")
+		assert(map.k == "v" and map.empty == "")
+		assert(next(none) == nil)
+		mixed = {1, 2.5, "x"}
+	`)
+	if err != nil {
+		t.Fatalf("Error checking pushed tables: %v", err)
+	}
+
+	L.GetGlobal("strings")
+	if v, ok := L.ToStrings(-1); !ok || len(v) != 3 || v[0] != "a" || v[1] != "" || v[2] != "bc" {
+		t.Fatalf("Wrong strings read: %v %v", v, ok)
+	}
+	L.GetGlobal("mixed")
+	if v, ok := L.ToFloat64s(-1); ok || len(v) != 2 || v[1] != 2.5 {
+		t.Fatalf("Wrong numbers read: %v %v", v, ok)
+	}
+	if v, ok := L.ToInt64s(-1); ok || len(v) != 1 || v[0] != 1 {
+		t.Fatalf("Wrong integers read: %v %v", v, ok)
+	}
+	if v, ok := L.ToStrings(-1); !ok || v[1] != "2.5" {
+		t.Fatalf("Numbers not converted to strings: %v %v", v, ok)
+	}
+}
+
+func BenchmarkPushInt64s(b *testing.B) {
+	L := NewState()
+	defer L.Close()
+	v := make([]int64, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		L.PushInt64s(v)
+		L.Pop(1)
+	}
+}
+
+func BenchmarkPushInt64sLoop(b *testing.B) {
+	L := NewState()
+	defer L.Close()
+	v := make([]int64, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		L.CreateTable(len(v), 0)
+		for j, n := range v {
+			L.PushInteger(n)
+			L.RawSeti(-2, int64(j)+1)
+		}
+		L.Pop(1)
+	}
+}
+
+func TestBatchNotTable(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+
+	L.PushString("not a table")
+	L.NewUserdata(16)
+	L.PushNil()
+	for i := 1; i <= 3; i++ {
+		if v, ok := L.ToFloat64s(i); ok || v != nil {
+			t.Fatalf("ToFloat64s accepted a %s: %v", L.Typename(int(L.Type(i))), v)
+		}
+		if v, ok := L.ToInt64s(i); ok || v != nil {
+			t.Fatalf("ToInt64s accepted a %s: %v", L.Typename(int(L.Type(i))), v)
+		}
+		if v, ok := L.ToStrings(i); ok || v != nil {
+			t.Fatalf("ToStrings accepted a %s: %v", L.Typename(int(L.Type(i))), v)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package lua
+
+import "errors"
+
+// Default number of instructions executed between two updates of the instruction budget accounting,
+// also the interval at which calls without budget poll for interruptions
+const budgetGranularity = 1000
+
+// The reason of the InterruptError returned by calls exceeding the instruction budget
+var ErrBudgetExceeded = errors.New("instruction budget exceeded")
+
+// Returns the number of instructions to execute before the next update of the budget accounting, 0 if the budget is exhausted
+func (L *State) budgetStep() int {
+	remaining := L.budget - L.budgetUsed
+	if remaining <= 0 {
+		return 0
+	}
+	if step := L.granularity(); remaining > int64(step) {
+		return step
+	}
+	return int(remaining)
+}
+
+func (L *State) granularity() int {
+	if L.budgetStepSize > 0 {
+		return L.budgetStepSize
+	}
+	return budgetGranularity
+}
+
+// Sets the number of instructions executed between two updates of the instruction budget accounting, 1000 by default.
+// A value of 0 or less restores the default.
+//
+// Instructions are accounted in whole steps, so the counts reported by InstructionsUsed and CallBudget are lower bounds
+// that miss the instructions executed since the last complete step of each call (less than n). A granularity of 1 gives exact counts,
+// at the cost of a call to Go for every lua instruction executed while a budget is set.
+func (L *State) SetBudgetGranularity(n int) {
+	if n < 0 {
+		n = 0
+	}
+	L.budgetStepSize = n
+	L.resetHook()
+}
+
+// Limits the number of lua instructions executed by calls on L to n and resets the count of used instructions.
+//
+// Once the budget is exhausted the running call returns an InterruptError wrapping ErrBudgetExceeded, as does every
+// following call until the budget is refilled or cleared. A budget of 0 or less removes the limit.
+func (L *State) SetInstructionBudget(n int64) {
+	if n < 0 {
+		n = 0
+	}
+	L.budget = n
+	L.budgetUsed = 0
+	L.resetHook()
+}
+
+// Adds n instructions to the instruction budget of L.
+func (L *State) RefillInstructionBudget(n int64) {
+	if L.budget <= 0 {
+		L.SetInstructionBudget(n)
+		return
+	}
+	L.budget += n
+	L.resetHook()
+}
+
+// Removes the instruction budget of L.
+func (L *State) ClearInstructionBudget() {
+	L.SetInstructionBudget(0)
+}
+
+// Returns the number of instructions left in the budget of L, ok is false if L has no instruction budget.
+func (L *State) InstructionBudget() (remaining int64, ok bool) {
+	if L.budget <= 0 {
+		return 0, false
+	}
+	if L.budgetUsed >= L.budget {
+		return 0, true
+	}
+	return L.budget - L.budgetUsed, true
+}
+
+// Returns the number of instructions executed since the instruction budget was set.
+//
+// Instructions are accounted in steps (see SetBudgetGranularity), with the default granularity this is a lower bound
+// that misses up to 999 instructions per call.
+func (L *State) InstructionsUsed() int64 {
+	return L.budgetUsed
+}
+
+// Like Call but executes at most budget instructions, returns the number of instructions used by the call,
+// which is exact only with a granularity of 1 (see SetBudgetGranularity).
+//
+// The instruction budget of L, if any, is restored afterwards and charged with the instructions used.
+func (L *State) CallBudget(budget int64, nargs, nresults int) (used int64, err error) {
+	prevBudget, prevUsed := L.budget, L.budgetUsed
+	L.SetInstructionBudget(budget)
+	err = L.Call(nargs, nresults)
+	used = L.budgetUsed
+	L.budget = prevBudget
+	L.budgetUsed = prevUsed
+	if prevBudget > 0 {
+		L.budgetUsed += used
+	}
+	L.resetHook()
+	return used, err
+}
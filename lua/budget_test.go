@@ -0,0 +1,82 @@
+package lua
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInstructionBudget(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	L.SetInstructionBudget(10000)
+	err := L.DoString("while true do end")
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Infinite loop did not exceed the budget: %v", err)
+	}
+	if used := L.InstructionsUsed(); used != 10000 {
+		t.Fatalf("Wrong number of instructions used: %d", used)
+	}
+	if remaining, ok := L.InstructionBudget(); !ok || remaining != 0 {
+		t.Fatalf("Wrong remaining budget: %d %v", remaining, ok)
+	}
+	L.SetTop(0)
+
+	if err := L.DoString("return 1"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Call with exhausted budget did not fail: %v", err)
+	}
+	L.SetTop(0)
+
+	L.RefillInstructionBudget(1000)
+	if err := L.DoString("return 1"); err != nil {
+		t.Fatalf("Call failed after refilling the budget: %v", err)
+	}
+	L.SetTop(0)
+
+	L.ClearInstructionBudget()
+	if _, ok := L.InstructionBudget(); ok {
+		t.Fatal("Budget not cleared")
+	}
+
+	L.LoadString("local x = 0 for i = 1, 10000 do x = x + i end return x")
+	used, err := L.CallBudget(1000000, 0, 1)
+	if err != nil {
+		t.Fatalf("Error executing call with budget: %v", err)
+	}
+	if used <= 0 || used >= 1000000 {
+		t.Fatalf("Wrong number of instructions used by the call: %d", used)
+	}
+	if L.ToInteger(-1) != 50005000 {
+		t.Fatal("Wrong result of call with budget")
+	}
+	if _, ok := L.InstructionBudget(); ok {
+		t.Fatal("Budget of the call was not removed")
+	}
+}
+
+func TestBudgetGranularity(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	// a call shorter than one step is not accounted with the default granularity
+	L.LoadString("local x = 1")
+	if used, err := L.CallBudget(1000000, 0, 0); err != nil || used != 0 {
+		t.Fatalf("Short call accounted with the default granularity: %d %v", used, err)
+	}
+
+	L.SetBudgetGranularity(1)
+	L.LoadString("local x = 1")
+	if used, err := L.CallBudget(1000000, 0, 0); err != nil || used == 0 {
+		t.Fatalf("Short call not accounted with a granularity of 1: %d %v", used, err)
+	}
+
+	L.SetInstructionBudget(5)
+	if err := L.DoString("local x = 0 for i = 1, 10 do x = x + i end"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Budget smaller than the default granularity not enforced: %v", err)
+	}
+	if used := L.InstructionsUsed(); used != 5 {
+		t.Fatalf("Wrong number of instructions used: %d", used)
+	}
+}
@@ -1,17 +1,34 @@
+#if defined(__linux__)
+#define _GNU_SOURCE /* for fopencookie */
+#endif
+
 #include <lua.h>
 #include <lauxlib.h>
 #include <lualib.h>
+#include "golua_compat.h"
+#include <errno.h>
 #include <stdint.h>
 #include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
 #include "_cgo_export.h"
 
 #define MT_GOFUNCTION "GoLua.GoFunction"
 #define MT_GOINTERFACE "GoLua.GoInterface"
+#define MT_GOSTREAMGUARD "GoLua.GoStreamGuard"
 
 #define GOLUA_DEFAULT_MSGHANDLER "golua_default_msghandler"
+#define GOLUA_INTERRUPTED 1
+#define GOLUA_BUDGET_EXCEEDED 2
 
-static const char GoStateRegistryKey = 'k'; //golua registry key
 static const char PanicFIDRegistryKey = 'k';
+/* error values raised by the interruption hooks, lua code can't create these
+ * light userdata so it can't fake an interruption with error() */
+static const char InterruptedErrorKey = 'i';
+static const char BudgetExceededErrorKey = 'b';
+#if LUA_VERSION_NUM < 502
+static const char GoStateRegistryKey = 'g';
+#endif
 
 typedef struct _chunk {
 	int size; // chunk size
@@ -37,9 +54,26 @@ void *testudata(lua_State *L, int ud, const char *tname)
 	return NULL;  /* value is not a userdata with a metatable */
 }
 
+static int callback_c (lua_State* L);
+
+/* returns the id of a go function, looking into the closure wrapping it if the
+ * value isn't a bare GoLua.GoFunction userdata */
+static unsigned int* clua_checkgofunction(lua_State* L, int index)
+{
+	unsigned int *fid;
+	if (lua_tocfunction(L, index) != &callback_c)
+		return testudata(L, index, MT_GOFUNCTION);
+	if (lua_getupvalue(L, index, 1) == NULL)
+		return NULL;
+	// the userdata stays referenced by the closure after being popped
+	fid = testudata(L, -1, MT_GOFUNCTION);
+	lua_pop(L, 1);
+	return fid;
+}
+
 int clua_isgofunction(lua_State *L, int n)
 {
-	return testudata(L, n, MT_GOFUNCTION) != NULL;
+	return clua_checkgofunction(L, n) != NULL;
 }
 
 int clua_isgostruct(lua_State *L, int n)
@@ -63,13 +97,20 @@ unsigned int* clua_checkgosomething(lua_State* L, int index, const char *desired
 
 size_t clua_getgostate(lua_State* L)
 {
+#if LUA_VERSION_NUM < 502
+	//LuaJIT has no extra space, the index is kept in the registry shared by
+	//all threads
 	size_t gostateindex;
-	//get gostate from registry entry
-	lua_pushlightuserdata(L,(void*)&GoStateRegistryKey);
-	lua_gettable(L, LUA_REGISTRYINDEX);
-	gostateindex = (size_t)lua_touserdata(L,-1);
-	lua_pop(L,1);
+	lua_pushlightuserdata(L, (void *)&GoStateRegistryKey);
+	lua_rawget(L, LUA_REGISTRYINDEX);
+	gostateindex = (size_t)lua_touserdata(L, -1);
+	lua_pop(L, 1);
 	return gostateindex;
+#else
+	//the index of the go state is stored in the extra space of the lua_State,
+	//threads inherit it from the main thread
+	return *(size_t *)lua_getextraspace(L);
+#endif
 }
 
 
@@ -97,7 +138,7 @@ int gchook_wrapper(lua_State* L)
 
 unsigned int clua_togofunction(lua_State* L, int index)
 {
-	unsigned int *r = clua_checkgosomething(L, index, MT_GOFUNCTION);
+	unsigned int *r = clua_checkgofunction(L, index);
 	return (r != NULL) ? *r : -1;
 }
 
@@ -127,6 +168,15 @@ void clua_pushcallback(lua_State* L)
 	lua_pushcclosure(L,callback_c,1);
 }
 
+/* pushes a go function closing over the n values on top of the stack, the go
+ * function userdata is always the first upvalue of the resulting closure */
+void clua_pushgoclosure(lua_State* L, unsigned int fid, int n)
+{
+	clua_pushgofunction(L, fid);
+	lua_rotate(L, -(n+1), 1);
+	lua_pushcclosure(L, callback_c, n+1);
+}
+
 void clua_pushgostruct(lua_State* L, unsigned int iid)
 {
 	unsigned int* iidptr = (unsigned int *)lua_newuserdata(L, sizeof(unsigned int));
@@ -145,10 +195,13 @@ int default_panicf(lua_State *L)
 void clua_setgostate(lua_State* L, size_t gostateindex)
 {
 	lua_atpanic(L, default_panicf);
-	lua_pushlightuserdata(L,(void*)&GoStateRegistryKey);
-	lua_pushlightuserdata(L, (void*)gostateindex);
-	//set into registry table
-	lua_settable(L, LUA_REGISTRYINDEX);
+#if LUA_VERSION_NUM < 502
+	lua_pushlightuserdata(L, (void *)&GoStateRegistryKey);
+	lua_pushlightuserdata(L, (void *)gostateindex);
+	lua_rawset(L, LUA_REGISTRYINDEX);
+#else
+	*(size_t *)lua_getextraspace(L) = gostateindex;
+#endif
 }
 
 static int writer (lua_State *L, const void* b, size_t size, void* B) {
@@ -165,7 +218,11 @@ int dump_chunk (lua_State *L) {
 	lua_settop(L, -1);
 	luaL_buffinit(L,&b);
 	int lerrno;
+#if LUA_VERSION_NUM < 503
+	lerrno = lua_dump(L, writer, &b);
+#else
 	lerrno = lua_dump(L, writer, &b, 0);
+#endif
 	if (lerrno != 0){
 	return luaL_error(L, "unable to dump given function, lerrno:%d", lerrno);
 	}
@@ -194,7 +251,11 @@ int load_chunk(lua_State *L, char *b, int size, const char* chunk_name) {
 	ck.buffer = b;
 	ck.size = size;
 	int lerrno;
+#if LUA_VERSION_NUM < 502
+	lerrno = lua_loadx(L, reader, &ck, chunk_name, NULL);
+#else
 	lerrno = lua_load(L, reader, &ck, chunk_name, NULL);
+#endif
 	if (lerrno != 0) {
 		return luaL_error(L, "unable to load chunk, lerrno: %d", lerrno);
 	}
@@ -265,9 +326,29 @@ int interface_newindex_callback(lua_State *L)
 	}
 }
 
+/* returns GOLUA_INTERRUPTED or GOLUA_BUDGET_EXCEEDED if the value at index is
+ * an error raised by the interruption hooks, 0 otherwise */
+int clua_interrupterror(lua_State *L, int index)
+{
+	void *p;
+	if (lua_type(L, index) != LUA_TLIGHTUSERDATA)
+		return 0;
+	p = lua_touserdata(L, index);
+	if (p == (void *)&InterruptedErrorKey)
+		return GOLUA_INTERRUPTED;
+	if (p == (void *)&BudgetExceededErrorKey)
+		return GOLUA_BUDGET_EXCEEDED;
+	return 0;
+}
+
 int panic_msghandler(lua_State *L)
 {
-	size_t gostateindex = clua_getgostate(L);
+	size_t gostateindex;
+	/* interruptions are reported once the protected call returns, unwinding
+	 * the stack normally re-enables the hooks disabled while the hook runs */
+	if (clua_interrupterror(L, -1))
+		return 1;
+	gostateindex = clua_getgostate(L);
 	go_panic_msghandler(gostateindex, (char *)lua_tolstring(L, -1, NULL));
 	return 0;
 }
@@ -287,6 +368,14 @@ void clua_hide_pcall(lua_State *L)
 
 void clua_initstate(lua_State* L)
 {
+#if LUA_VERSION_NUM < 502
+	/* lua 5.1 doesn't keep these in the registry, see golua_compat.h */
+	lua_pushthread(L);
+	lua_rawseti(L, LUA_REGISTRYINDEX, LUA_RIDX_MAINTHREAD);
+	lua_pushvalue(L, LUA_GLOBALSINDEX);
+	lua_rawseti(L, LUA_REGISTRYINDEX, LUA_RIDX_GLOBALS);
+#endif
+
 	/* create the GoLua.GoFunction metatable */
 	luaL_newmetatable(L, MT_GOFUNCTION);
 
@@ -299,6 +388,12 @@ void clua_initstate(lua_State* L)
 	lua_pushliteral(L,"__gc");
 	lua_pushcfunction(L,&gchook_wrapper);
 	lua_settable(L,-3);
+
+	// gofunction_metatable[__metatable] = false, lua code must not be able
+	// to call __gc and unregister a live go value
+	lua_pushliteral(L,"__metatable");
+	lua_pushboolean(L,0);
+	lua_settable(L,-3);
 	lua_pop(L,1);
 
 	luaL_newmetatable(L, MT_GOINTERFACE);
@@ -318,6 +413,11 @@ void clua_initstate(lua_State* L)
 	lua_pushcfunction(L, &interface_newindex_callback);
 	lua_settable(L, -3);
 
+	// gointerface_metatable[__metatable] = false
+	lua_pushliteral(L, "__metatable");
+	lua_pushboolean(L, 0);
+	lua_settable(L, -3);
+
 	lua_register(L, GOLUA_DEFAULT_MSGHANDLER, &panic_msghandler);
 	lua_pop(L, 1);
 }
@@ -386,6 +486,91 @@ void clua_setallocf(lua_State* L, void* goallocf)
 	lua_setallocf(L,&allocwrapper,goallocf);
 }
 
+/* memory usage of a state created with clua_newstatelimited */
+typedef struct {
+	size_t limit;
+	size_t current;
+	size_t peak;
+	size_t allocations;
+} clua_memstats;
+
+/* allocator implemented with realloc/free that keeps usage statistics and
+ * refuses to grow the heap past stats->limit, when the limit is not 0 */
+static void* limitedalloc(void* ud, void *ptr, size_t osize, size_t nsize)
+{
+	clua_memstats* stats = (clua_memstats*)ud;
+	void* p;
+	// when ptr is NULL osize encodes the type of the object being allocated
+	if (ptr == NULL)
+		osize = 0;
+	if (nsize == 0)
+	{
+		free(ptr);
+		stats->current -= osize;
+		return NULL;
+	}
+	if (stats->limit != 0 && nsize > osize && stats->current - osize + nsize > stats->limit)
+		return NULL;
+	p = realloc(ptr, nsize);
+	if (p == NULL)
+		return NULL;
+	stats->current = stats->current - osize + nsize;
+	if (stats->current > stats->peak)
+		stats->peak = stats->current;
+	if (ptr == NULL)
+		stats->allocations++;
+	return p;
+}
+
+lua_State* clua_newstatelimited(size_t limit)
+{
+	lua_State* L;
+	clua_memstats* stats = (clua_memstats*)calloc(1, sizeof(clua_memstats));
+	if (stats == NULL)
+		return NULL;
+	stats->limit = limit;
+	L = lua_newstate(&limitedalloc, stats);
+	if (L == NULL)
+		free(stats);
+	return L;
+}
+
+static clua_memstats* clua_memstatsof(lua_State* L)
+{
+	void* ud;
+	if (lua_getallocf(L, &ud) != &limitedalloc)
+		return NULL;
+	return (clua_memstats*)ud;
+}
+
+int clua_setmemlimit(lua_State* L, size_t limit)
+{
+	clua_memstats* stats = clua_memstatsof(L);
+	if (stats == NULL)
+		return 0;
+	stats->limit = limit;
+	return 1;
+}
+
+int clua_getmemstats(lua_State* L, size_t* limit, size_t* current, size_t* peak, size_t* allocations)
+{
+	clua_memstats* stats = clua_memstatsof(L);
+	if (stats == NULL)
+		return 0;
+	*limit = stats->limit;
+	*current = stats->current;
+	*peak = stats->peak;
+	*allocations = stats->allocations;
+	return 1;
+}
+
+void clua_close(lua_State* L)
+{
+	clua_memstats* stats = clua_memstatsof(L);
+	lua_close(L);
+	free(stats);
+}
+
 void clua_openbase(lua_State* L)
 {
 	lua_pushcfunction(L,&luaopen_base);
@@ -432,8 +617,11 @@ void clua_openos(lua_State* L)
 
 void clua_opencoroutine(lua_State *L)
 {
+#if LUA_VERSION_NUM >= 502
 	luaL_requiref(L, "coroutine", &luaopen_coroutine, 1);
 	lua_pop(L, 1);
+#endif
+	/* in LuaJIT coroutine is opened by the base library */
 }
 
 void clua_opendebug(lua_State *L)
@@ -444,8 +632,12 @@ void clua_opendebug(lua_State *L)
 
 void clua_openbit32(lua_State *L)
 {
+#if LUA_VERSION_NUM >= 502 && LUA_VERSION_NUM < 504
 	luaL_requiref(L, "bit32", &luaopen_bit32, 1);
 	lua_pop(L, 1);
+#endif
+	/* the bit32 library was removed in lua 5.4, LuaJIT has its own bit
+	 * library opened by luaL_openlibs */
 }
 
 void clua_hook_function(lua_State *L, lua_Debug *ar)
@@ -460,4 +652,483 @@ void clua_setexecutionlimit(lua_State* L, int n)
 	lua_sethook(L, &clua_hook_function, LUA_MASKCOUNT, n);
 }
 
+void clua_interrupt_hook(lua_State *L, lua_Debug *ar)
+{
+	lua_checkstack(L, 2);
+	lua_pushlightuserdata(L, (void *)&InterruptedErrorKey);
+	lua_error(L);
+}
+
+/* lua_sethook can be called from any thread, the hook stays installed until
+ * the interruption is reported so that it can't be caught by lua code */
+void clua_interrupt(lua_State* L)
+{
+	lua_sethook(L, &clua_interrupt_hook, LUA_MASKCALL | LUA_MASKRET | LUA_MASKLINE | LUA_MASKCOUNT, 1);
+}
+
+/* count hook accounting the instructions executed against the budget of the
+ * go state, the go side returns the count of the next step, 0 when the budget
+ * is exhausted or -1 when an interruption is pending */
+static void clua_budget_hook(lua_State *L, lua_Debug *ar)
+{
+	int count = lua_gethookcount(L);
+	int next = golua_budgethook(clua_getgostate(L), count);
+	if (next > 0)
+	{
+		if (next != count)
+			lua_sethook(L, &clua_budget_hook, LUA_MASKCOUNT, next);
+		return;
+	}
+	lua_checkstack(L, 2);
+	lua_pushlightuserdata(L, (next < 0) ? (void *)&InterruptedErrorKey : (void *)&BudgetExceededErrorKey);
+	lua_error(L);
+}
+
+void clua_setbudgethook(lua_State* L, int count)
+{
+	lua_sethook(L, &clua_budget_hook, LUA_MASKCOUNT, count);
+}
+
+/* file handles backed by go streams, every operation on the FILE is forwarded
+ * to the go value registered with id, this needs fopencookie (glibc) or
+ * funopen (BSD and macOS) */
+#if defined(__linux__) || defined(__APPLE__) || defined(__FreeBSD__)
+#define GOLUA_GOSTREAM
+
+typedef struct {
+	size_t gostateindex;
+	unsigned int id;
+} clua_gostream;
+
+static long gostream_read(void *cookie, char *buf, size_t size)
+{
+	clua_gostream *s = (clua_gostream *)cookie;
+	GoInt n = golua_streamread(s->gostateindex, s->id, buf, size);
+	if (n < 0)
+		errno = EIO;
+	return n;
+}
+
+static long gostream_write(void *cookie, const char *buf, size_t size)
+{
+	clua_gostream *s = (clua_gostream *)cookie;
+	GoInt n = golua_streamwrite(s->gostateindex, s->id, (void *)buf, size);
+	if (n < 0)
+		errno = EIO;
+	return n;
+}
+
+static long long gostream_seek(void *cookie, long long offset, int whence)
+{
+	clua_gostream *s = (clua_gostream *)cookie;
+	GoInt64 pos = golua_streamseek(s->gostateindex, s->id, offset, whence);
+	if (pos < 0)
+		errno = ESPIPE;
+	return pos;
+}
+
+static int gostream_close(void *cookie)
+{
+	clua_gostream *s = (clua_gostream *)cookie;
+	int r = golua_streamclose(s->gostateindex, s->id);
+	free(s);
+	if (r < 0)
+	{
+		errno = EIO;
+		return EOF;
+	}
+	return 0;
+}
+
+#if defined(__linux__)
+static ssize_t gostream_cookieread(void *cookie, char *buf, size_t size)
+{
+	return gostream_read(cookie, buf, size);
+}
+
+static ssize_t gostream_cookiewrite(void *cookie, const char *buf, size_t size)
+{
+	long n = gostream_write(cookie, buf, size);
+	/* fopencookie expects 0 on errors */
+	return (n < 0) ? 0 : n;
+}
+
+static int gostream_cookieseek(void *cookie, off64_t *offset, int whence)
+{
+	long long pos = gostream_seek(cookie, *offset, whence);
+	if (pos < 0)
+		return -1;
+	*offset = pos;
+	return 0;
+}
+
+static FILE* gostream_open(clua_gostream *cookie, const char *mode)
+{
+	cookie_io_functions_t funcs = { gostream_cookieread, gostream_cookiewrite, gostream_cookieseek, gostream_close };
+	return fopencookie(cookie, mode, funcs);
+}
+#else
+static int gostream_funread(void *cookie, char *buf, int size)
+{
+	return (int)gostream_read(cookie, buf, size);
+}
+
+static int gostream_funwrite(void *cookie, const char *buf, int size)
+{
+	return (int)gostream_write(cookie, buf, size);
+}
 
+static fpos_t gostream_funseek(void *cookie, fpos_t offset, int whence)
+{
+	return (fpos_t)gostream_seek(cookie, offset, whence);
+}
+
+static FILE* gostream_open(clua_gostream *cookie, const char *mode)
+{
+	int writable = (strchr(mode, 'w') != NULL || strchr(mode, 'a') != NULL || strchr(mode, '+') != NULL);
+	int readable = (strchr(mode, 'r') != NULL || strchr(mode, '+') != NULL);
+	return funopen(cookie, readable ? gostream_funread : NULL, writable ? gostream_funwrite : NULL, gostream_funseek, gostream_close);
+}
+#endif
+#endif
+
+#if LUA_VERSION_NUM >= 502 && defined(GOLUA_GOSTREAM)
+static int gostream_lclose(lua_State *L)
+{
+	luaL_Stream *p = (luaL_Stream *)luaL_checkudata(L, 1, LUA_FILEHANDLE);
+	int res = fclose(p->f);
+	return luaL_fileresult(L, (res == 0), NULL);
+}
+
+static int gostream_lnoclose(lua_State *L)
+{
+	luaL_Stream *p = (luaL_Stream *)luaL_checkudata(L, 1, LUA_FILEHANDLE);
+	p->closef = &gostream_lnoclose; /* keep file opened */
+	lua_pushnil(L);
+	lua_pushliteral(L, "cannot close standard file");
+	return 2;
+}
+
+static int gostream_guardgc(lua_State *L)
+{
+	FILE **guard = (FILE **)lua_touserdata(L, 1);
+	if (*guard != NULL)
+	{
+		fclose(*guard);
+		*guard = NULL;
+	}
+	return 0;
+}
+
+/* pushes a file handle of the io library backed by the go stream registered
+ * with id, returns 0 if the stream couldn't be opened */
+int clua_pushgostream(lua_State *L, unsigned int id, const char *mode, int closable)
+{
+	clua_gostream *cookie;
+	FILE **guard;
+	luaL_Stream *p = (luaL_Stream *)lua_newuserdata(L, sizeof(luaL_Stream));
+	p->f = NULL;
+	p->closef = NULL; /* mark file handle as closed until the stream is opened */
+	if (luaL_getmetatable(L, LUA_FILEHANDLE) == LUA_TNIL)
+	{
+		/* the metatable of file handles is created by the io library */
+		lua_pop(L, 1);
+		luaL_requiref(L, LUA_IOLIBNAME, &luaopen_io, 0);
+		lua_pop(L, 1);
+		luaL_getmetatable(L, LUA_FILEHANDLE);
+	}
+	lua_setmetatable(L, -2);
+
+	cookie = (clua_gostream *)malloc(sizeof(clua_gostream));
+	if (cookie == NULL)
+		return 0;
+	cookie->gostateindex = clua_getgostate(L);
+	cookie->id = id;
+	p->f = gostream_open(cookie, mode);
+	if (p->f == NULL)
+	{
+		free(cookie);
+		return 0;
+	}
+	if (closable)
+	{
+		p->closef = &gostream_lclose;
+		return 1;
+	}
+	p->closef = &gostream_lnoclose;
+	/* standard output streams are unbuffered so that their output is never
+	 * held back, stdin stays buffered to avoid a go call per byte read */
+	if (strchr(mode, 'r') == NULL)
+		setvbuf(p->f, NULL, _IONBF, 0);
+	/* the FILE of a standard stream is closed when this guard, referenced
+	 * only by the file handle, is collected */
+	guard = (FILE **)lua_newuserdata(L, sizeof(FILE *));
+	*guard = p->f;
+	if (luaL_newmetatable(L, MT_GOSTREAMGUARD))
+	{
+		lua_pushcfunction(L, &gostream_guardgc);
+		lua_setfield(L, -2, "__gc");
+	}
+	lua_setmetatable(L, -2);
+	lua_setuservalue(L, -2);
+	return 1;
+}
+#else
+/* the file handles of LuaJIT can't be created outside of its io library, and
+ * other platforms (Windows) have no way to create a FILE from callbacks */
+int clua_pushgostream(lua_State *L, unsigned int id, const char *mode, int closable)
+{
+	(void)L;
+	(void)id;
+	(void)mode;
+	(void)closable;
+	return 0;
+}
+#endif
+
+/* returns 1 if clua_pushgostream is supported by this build */
+int clua_hasgostream(void)
+{
+#if LUA_VERSION_NUM >= 502 && defined(GOLUA_GOSTREAM)
+	return 1;
+#else
+	return 0;
+#endif
+}
+
+/* variants of lua_getfield, lua_setfield, lua_getglobal and lua_setglobal
+ * taking keys that aren't NUL terminated, so that go strings can be passed
+ * without copying them */
+int clua_getfieldn(lua_State *L, int index, const char *k, size_t len)
+{
+	index = lua_absindex(L, index);
+	lua_pushlstring(L, k, len);
+	lua_gettable(L, index);
+	return lua_type(L, -1);
+}
+
+void clua_setfieldn(lua_State *L, int index, const char *k, size_t len)
+{
+	index = lua_absindex(L, index);
+	lua_pushlstring(L, k, len);
+	lua_insert(L, -2);
+	lua_settable(L, index);
+}
+
+int clua_getglobaln(lua_State *L, const char *name, size_t len)
+{
+	int t;
+	lua_rawgeti(L, LUA_REGISTRYINDEX, LUA_RIDX_GLOBALS);
+	lua_pushlstring(L, name, len);
+	lua_gettable(L, -2);
+	t = lua_type(L, -1);
+	lua_remove(L, -2);
+	return t;
+}
+
+void clua_setglobaln(lua_State *L, const char *name, size_t len)
+{
+	lua_rawgeti(L, LUA_REGISTRYINDEX, LUA_RIDX_GLOBALS);
+	lua_insert(L, -2);
+	lua_pushlstring(L, name, len);
+	lua_insert(L, -2);
+	lua_settable(L, -3);
+	lua_pop(L, 1);
+}
+
+/* batch operations on array tables, performing a single cgo call for a whole
+ * go slice */
+void clua_pushnumbers(lua_State *L, const lua_Number *v, size_t n)
+{
+	size_t i;
+	lua_createtable(L, (int)n, 0);
+	for (i = 0; i < n; i++)
+	{
+		lua_pushnumber(L, v[i]);
+		lua_rawseti(L, -2, (lua_Integer)i + 1);
+	}
+}
+
+void clua_pushintegers(lua_State *L, const lua_Integer *v, size_t n)
+{
+	size_t i;
+	lua_createtable(L, (int)n, 0);
+	for (i = 0; i < n; i++)
+	{
+		lua_pushinteger(L, v[i]);
+		lua_rawseti(L, -2, (lua_Integer)i + 1);
+	}
+}
+
+/* pushes an array of the n strings concatenated in buf, the length of each
+ * string is in lens */
+void clua_pushstrings(lua_State *L, const char *buf, const size_t *lens, size_t n)
+{
+	size_t i;
+	lua_createtable(L, (int)n, 0);
+	for (i = 0; i < n; i++)
+	{
+		lua_pushlstring(L, buf, lens[i]);
+		buf += lens[i];
+		lua_rawseti(L, -2, (lua_Integer)i + 1);
+	}
+}
+
+/* pushes a table of the n key/value pairs concatenated in buf as key1 value1
+ * key2 value2..., lens holds the 2*n lengths */
+void clua_pushstringmap(lua_State *L, const char *buf, const size_t *lens, size_t n)
+{
+	size_t i;
+	lua_createtable(L, 0, (int)n);
+	for (i = 0; i < n; i++)
+	{
+		lua_pushlstring(L, buf, lens[2*i]);
+		buf += lens[2*i];
+		lua_pushlstring(L, buf, lens[2*i+1]);
+		buf += lens[2*i+1];
+		lua_rawset(L, -3);
+	}
+}
+
+/* the following functions read the first n elements of the array at index,
+ * they return 0 on success or the position of the first element that
+ * couldn't be converted */
+size_t clua_tonumbers(lua_State *L, int index, lua_Number *out, size_t n)
+{
+	size_t i;
+	int isnum;
+	index = lua_absindex(L, index);
+	for (i = 0; i < n; i++)
+	{
+		lua_rawgeti(L, index, (lua_Integer)i + 1);
+		out[i] = lua_tonumberx(L, -1, &isnum);
+		lua_pop(L, 1);
+		if (!isnum)
+			return i + 1;
+	}
+	return 0;
+}
+
+size_t clua_tointegers(lua_State *L, int index, lua_Integer *out, size_t n)
+{
+	size_t i;
+	int isnum;
+	index = lua_absindex(L, index);
+	for (i = 0; i < n; i++)
+	{
+		lua_rawgeti(L, index, (lua_Integer)i + 1);
+		out[i] = clua_tointegerx(L, -1, &isnum);
+		lua_pop(L, 1);
+		if (!isnum)
+			return i + 1;
+	}
+	return 0;
+}
+
+/* stores the length of the strings in lens and their total length in total */
+size_t clua_stringlens(lua_State *L, int index, size_t *lens, size_t n, size_t *total)
+{
+	size_t i;
+	index = lua_absindex(L, index);
+	*total = 0;
+	for (i = 0; i < n; i++)
+	{
+		lua_rawgeti(L, index, (lua_Integer)i + 1);
+		if (lua_type(L, -1) != LUA_TSTRING && lua_type(L, -1) != LUA_TNUMBER)
+		{
+			lua_pop(L, 1);
+			return i + 1;
+		}
+		lua_tolstring(L, -1, &lens[i]);
+		*total += lens[i];
+		lua_pop(L, 1);
+	}
+	return 0;
+}
+
+/* copies the strings measured by clua_stringlens into buf */
+void clua_copystrings(lua_State *L, int index, char *buf, size_t n)
+{
+	size_t i, len;
+	const char *s;
+	index = lua_absindex(L, index);
+	for (i = 0; i < n; i++)
+	{
+		lua_rawgeti(L, index, (lua_Integer)i + 1);
+		s = lua_tolstring(L, -1, &len);
+		memcpy(buf, s, len);
+		buf += len;
+		lua_pop(L, 1);
+	}
+}
+
+/* wrappers of the functions whose signature changed between lua versions or
+ * that cgo can't call directly (macros and variadic functions) */
+int clua_gc(lua_State *L, int what, int data)
+{
+	return lua_gc(L, what, data);
+}
+
+void *clua_newuserdata(lua_State *L, size_t size)
+{
+	return lua_newuserdata(L, size);
+}
+
+int clua_resume(lua_State *L, lua_State *from, int narg, int *nres)
+{
+#if LUA_VERSION_NUM >= 504
+	return lua_resume(L, from, narg, nres);
+#else
+#if LUA_VERSION_NUM < 502
+	int r = lua_resume(L, narg);
+	(void)from;
+#else
+	int r = lua_resume(L, from, narg);
+#endif
+	/* the stack only holds the yielded or returned values */
+	*nres = lua_gettop(L);
+	return r;
+#endif
+}
+
+#if LUA_VERSION_NUM >= 504
+int clua_gcgen(lua_State *L, int minormul, int majormul)
+{
+	return lua_gc(L, LUA_GCGEN, minormul, majormul);
+}
+
+int clua_gcinc(lua_State *L, int pause, int stepmul, int stepsize)
+{
+	return lua_gc(L, LUA_GCINC, pause, stepmul, stepsize);
+}
+
+static void clua_warnf(void *ud, const char *msg, int tocont)
+{
+	golua_warn((size_t)ud, (char *)msg, tocont);
+}
+
+void clua_setwarnf(lua_State *L, int enable)
+{
+	if (enable)
+		lua_setwarnf(L, &clua_warnf, (void *)clua_getgostate(L));
+	else
+		lua_setwarnf(L, NULL, NULL);
+}
+#endif
+
+/* sets the table on top of the stack as the environment of the chunk at
+ * index, returns 0 if the chunk has no environment. The table is popped in
+ * both cases */
+int clua_setchunkenv(lua_State *L, int index)
+{
+#if LUA_VERSION_NUM < 502
+	return lua_setfenv(L, index);
+#else
+	if (lua_setupvalue(L, index, 1) == NULL)
+	{
+		lua_pop(L, 1);
+		return 0;
+	}
+	return 1;
+#endif
+}
@@ -0,0 +1,297 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Options of the deterministic mode, see SetDeterministic
+type DeterministicOptions struct {
+	// Time source of os.time, os.clock and os.date, the clock is frozen at the unix epoch if nil
+	Clock func() time.Time
+	// Initial seed of math.random
+	Seed int64
+}
+
+// Functions removed in deterministic mode, their results depend on the host
+var nondeterministicFuncs = []string{
+	"os.execute", "os.getenv", "os.tmpname", "os.remove", "os.rename",
+	"io.popen", "io.tmpfile",
+}
+
+// Creates a new lua interpreter state with the standard libraries opened in deterministic mode, see SetDeterministic.
+func NewDeterministicState(opts DeterministicOptions) *State {
+	L := NewState()
+	L.OpenLibs()
+	L.SetDeterministic(opts)
+	return L
+}
+
+// Makes the standard libraries of L behave identically across runs and machines, to be called after the libraries are opened.
+//
+//   - os.time, os.clock and os.date read opts.Clock, os.date and os.time always use UTC
+//   - math.random is driven by a go generator seeded with opts.Seed, math.randomseed reseeds it
+//   - pairs iterates over booleans, numbers and strings keys in sorted order, before keys of other types
+//   - functions depending on the host (os.getenv, os.execute, io.popen, ...) are removed
+//
+// The order of next, and of pairs for keys that are tables, functions or userdata, still depends on memory addresses.
+func (L *State) SetDeterministic(opts DeterministicOptions) {
+	clock := opts.Clock
+	if clock == nil {
+		clock = func() time.Time { return time.Unix(0, 0) }
+	}
+	start := clock()
+	rnd := rand.New(rand.NewSource(opts.Seed))
+
+	L.RawGeti(LUA_REGISTRYINDEX, LUA_RIDX_GLOBALS)
+	globals := L.GetTop()
+
+	L.GetField(globals, "os")
+	if L.IsTable(-1) {
+		L.replaceField(-1, "time", func(L *State) int {
+			return detTime(L, clock)
+		})
+		L.replaceField(-1, "clock", func(L *State) int {
+			L.PushNumber(clock().Sub(start).Seconds())
+			return 1
+		})
+		L.replaceField(-1, "date", func(L *State) int {
+			return detDate(L, clock)
+		})
+	}
+	L.Pop(1)
+
+	L.GetField(globals, "math")
+	if L.IsTable(-1) {
+		L.replaceField(-1, "random", func(L *State) int {
+			return detRandom(L, rnd)
+		})
+		L.replaceField(-1, "randomseed", func(L *State) int {
+			rnd.Seed(int64(detCheckNumber(L, 1, "randomseed")))
+			return 0
+		})
+	}
+	L.Pop(1)
+
+	L.replaceField(globals, "pairs", detPairs)
+
+	for _, name := range nondeterministicFuncs {
+		i := strings.Index(name, ".")
+		L.GetField(globals, name[:i])
+		if L.IsTable(-1) {
+			L.PushString(name[i+1:])
+			L.PushNil()
+			L.RawSet(-3)
+		}
+		L.Pop(1)
+	}
+
+	L.Pop(1)
+}
+
+// Returns the number argument narg of the function fname, raises an error if it is not a number
+func detCheckNumber(L *State, narg int, fname string) float64 {
+	if L.Type(narg) != LUA_TNUMBER {
+		L.RaiseError(fmt.Sprintf("bad argument #%d to '%s' (number expected, got %s)", narg, fname, L.Typename(int(L.Type(narg)))))
+	}
+	return L.ToNumber(narg)
+}
+
+// Returns the integer argument narg of the function fname, raises an error if it has no integer representation
+func detCheckInteger(L *State, narg int, fname string) int64 {
+	n, ok := L.ToIntegerX(narg)
+	if !ok {
+		if L.Type(narg) == LUA_TNUMBER {
+			L.RaiseError(fmt.Sprintf("bad argument #%d to '%s' (number has no integer representation)", narg, fname))
+		}
+		L.RaiseError(fmt.Sprintf("bad argument #%d to '%s' (number expected, got %s)", narg, fname, L.Typename(int(L.Type(narg)))))
+	}
+	return n
+}
+
+// os.time ([table])
+func detTime(L *State, clock func() time.Time) int {
+	if L.IsNoneOrNil(1) {
+		L.PushInteger(clock().Unix())
+		return 1
+	}
+	if !L.IsTable(1) {
+		L.RaiseError(fmt.Sprintf("bad argument #1 to 'time' (table expected, got %s)", L.Typename(int(L.Type(1)))))
+	}
+	field := func(key string, def int64) int {
+		L.GetField(1, key)
+		defer L.Pop(1)
+		if L.IsNil(-1) {
+			if def < 0 {
+				L.RaiseError(fmt.Sprintf("field '%s' missing in date table", key))
+			}
+			return int(def)
+		}
+		return int(detCheckInteger(L, -1, "time"))
+	}
+	t := time.Date(field("year", -1), time.Month(field("month", -1)), field("day", -1), field("hour", 12), field("min", 0), field("sec", 0), 0, time.UTC)
+	L.PushInteger(t.Unix())
+	return 1
+}
+
+// os.date ([format [, time]]), the original os.date is the first upvalue
+func detDate(L *State, clock func() time.Time) int {
+	format := "%c"
+	if !L.IsNoneOrNil(1) {
+		format = fsCheckString(L, 1, "date")
+	}
+	if !strings.HasPrefix(format, "!") {
+		format = "!" + format
+	}
+	var t int64
+	if L.IsNoneOrNil(2) {
+		t = clock().Unix()
+	} else {
+		t = detCheckInteger(L, 2, "date")
+	}
+	L.SetTop(0)
+	L.PushValue(L.UpvalueIndex(1))
+	L.PushString(format)
+	L.PushInteger(t)
+	L.MustCall(2, 1)
+	return 1
+}
+
+// math.random ([m [, n]])
+func detRandom(L *State, rnd *rand.Rand) int {
+	var low, up int64
+	switch L.GetTop() {
+	case 0:
+		L.PushNumber(rnd.Float64())
+		return 1
+	case 1:
+		low = 1
+		up = detCheckInteger(L, 1, "random")
+	case 2:
+		low = detCheckInteger(L, 1, "random")
+		up = detCheckInteger(L, 2, "random")
+	default:
+		L.RaiseError("wrong number of arguments")
+	}
+	if low > up {
+		L.RaiseError(fmt.Sprintf("bad argument #%d to 'random' (interval is empty)", L.GetTop()))
+	}
+	if low < 0 && up > math.MaxInt64+low {
+		L.RaiseError("bad argument #1 to 'random' (interval too large)")
+	}
+	if up-low == math.MaxInt64 {
+		L.PushInteger(low + int64(rnd.Uint64()>>1))
+	} else {
+		L.PushInteger(low + rnd.Int63n(up-low+1))
+	}
+	return 1
+}
+
+// Sort key of a table key in deterministic pairs
+type detKey struct {
+	// position of the key in the array of collected keys
+	pos int64
+	// LUA_TBOOLEAN, LUA_TNUMBER or LUA_TSTRING, anything else is sorted last by collection order
+	t     LuaValType
+	b     bool
+	isint bool
+	i     int64
+	n     float64
+	s     string
+}
+
+func (a *detKey) less(b *detKey) bool {
+	rank := func(t LuaValType) int {
+		switch t {
+		case LUA_TBOOLEAN:
+			return 0
+		case LUA_TNUMBER:
+			return 1
+		case LUA_TSTRING:
+			return 2
+		}
+		return 3
+	}
+	if ra, rb := rank(a.t), rank(b.t); ra != rb {
+		return ra < rb
+	}
+	switch a.t {
+	case LUA_TBOOLEAN:
+		return !a.b && b.b
+	case LUA_TNUMBER:
+		if a.isint && b.isint {
+			return a.i < b.i
+		}
+		return a.n < b.n
+	case LUA_TSTRING:
+		return a.s < b.s
+	}
+	return a.pos < b.pos
+}
+
+// pairs (t), iterating in sorted key order, the original pairs is the first upvalue
+func detPairs(L *State) int {
+	if !L.IsTable(1) || L.GetMetaField(1, "__pairs") {
+		if L.IsTable(1) {
+			L.Pop(1)
+		}
+		L.PushValue(L.UpvalueIndex(1))
+		L.Insert(1)
+		L.MustCall(L.GetTop()-1, 3)
+		return 3
+	}
+	L.SetTop(1)
+
+	// collect the keys in a lua array, so that they can be sorted in go
+	L.NewTable()
+	keys := []*detKey{}
+	L.PushNil()
+	for L.Next(1) != 0 {
+		L.Pop(1)
+		k := &detKey{pos: int64(len(keys)) + 1, t: L.Type(-1)}
+		switch k.t {
+		case LUA_TBOOLEAN:
+			k.b = L.ToBoolean(-1)
+		case LUA_TNUMBER:
+			k.isint = L.IsInteger(-1)
+			k.i = L.ToInteger(-1)
+			k.n = L.ToNumber(-1)
+		case LUA_TSTRING:
+			k.s = L.ToString(-1)
+		}
+		keys = append(keys, k)
+		L.PushValue(-1)
+		L.RawSeti(2, k.pos)
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+
+	L.CreateTable(len(keys), 0)
+	for i, k := range keys {
+		L.RawGeti(2, k.pos)
+		L.RawSeti(3, int64(i)+1)
+	}
+
+	next := int64(0)
+	L.PushGoClosureN(func(L *State) int {
+		for next < int64(len(keys)) {
+			next++
+			L.RawGeti(L.UpvalueIndex(1), next)
+			L.PushValue(-1)
+			L.RawGet(1)
+			// skip keys removed during the traversal
+			if !L.IsNil(-1) {
+				return 2
+			}
+			L.Pop(2)
+		}
+		L.PushNil()
+		return 1
+	}, 1)
+	L.PushValue(1)
+	L.PushNil()
+	return 3
+}
@@ -0,0 +1,39 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeterministic(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	run := func() string {
+		L := NewDeterministicState(DeterministicOptions{Clock: func() time.Time { return now }, Seed: 42})
+		defer L.Close()
+		err := L.DoString(`
+			local t = {zeta = 1, alpha = 2, [3] = 3, [1.5] = 4, [true] = 5}
+			local out = {}
+			for k in pairs(t) do out[#out+1] = tostring(k) end
+			for i = 1, 5 do out[#out+1] = tostring(math.random(100)) end
+			out[#out+1] = os.date('%Y-%m-%d %H:%M:%S')
+			out[#out+1] = tostring(os.time())
+			result = table.concat(out, ' ')
+			assert(os.getenv == nil and io.popen == nil)
+			assert(os.time({year = 2020, month = 1, day = 2, hour = 3, min = 4, sec = 5}) == os.time())
+		`)
+		if err != nil {
+			t.Fatalf("Error in deterministic state: %v", err)
+		}
+		L.GetGlobal("result")
+		return L.ToString(-1)
+	}
+
+	first := run()
+	if second := run(); first != second {
+		t.Fatalf("Runs differ: <%s> <%s>", first, second)
+	}
+	if !strings.HasPrefix(first, "true 1.5 3 alpha zeta ") {
+		t.Fatalf("Keys not sorted: %s", first)
+	}
+}
@@ -0,0 +1,270 @@
+package lua
+
+//#include <lua.h>
+//#include <lauxlib.h>
+//#include <stdlib.h>
+//#include "golua.h"
+import "C"
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Makes L resolve file names against fsys instead of the host filesystem, fsys can be an embed.FS, an fstest.MapFS, os.DirFS, ...
+//
+// LoadFile, DoFile and the lua functions loadfile, dofile and require load their chunks from fsys, while io.open and io.lines
+// open the files of fsys in read-only mode. File names are always relative to the root of fsys, "/scripts/a.lua" and "scripts/a.lua" name the same file.
+// Require keeps using package.path and package.preload but the searchers of C modules are removed.
+//
+// Only the functions already present in the environment are replaced, so SetFS must be called after the libraries are opened (and after ApplySandbox if any).
+// Other functions accessing the host filesystem (io.input, io.output, io.popen, os.remove, ...) are left untouched, use a Sandbox to remove them.
+func (L *State) SetFS(fsys fs.FS) {
+	L.fsys = fsys
+
+	L.RawGeti(LUA_REGISTRYINDEX, LUA_RIDX_GLOBALS)
+	L.replaceField(-1, "loadfile", fsLoadfile)
+	L.replaceField(-1, "dofile", fsDofile)
+	L.GetField(-1, "io")
+	if L.IsTable(-1) {
+		L.replaceField(-1, "open", fsOpen)
+		L.replaceField(-1, "lines", fsLines)
+	}
+	L.Pop(2)
+
+	if L.getSearchers() {
+		L.setFSSearchers(L.GetTop())
+		L.Pop(1)
+	}
+}
+
+// Replaces the function stored in the field name of the table at index, if there is one.
+// The previous value is available to f as its first upvalue.
+func (L *State) replaceField(index int, name string, f LuaGoFunction) {
+	index = L.AbsIndex(index)
+	L.PushString(name)
+	L.RawGet(index)
+	if L.IsNil(-1) {
+		L.Pop(1)
+		return
+	}
+	L.PushGoClosureN(f, 1)
+	L.PushString(name)
+	L.Insert(-2)
+	L.RawSet(index)
+}
+
+// Replaces the searchers of lua and C modules by a searcher looking for lua modules in the file system set with SetFS
+func (L *State) setFSSearchers(searchers int) {
+	n := int64(L.ObjLen(searchers))
+	if n < 2 {
+		return
+	}
+	L.PushGoFunction(fsSearcher)
+	L.RawSeti(searchers, 2)
+	if n < 4 {
+		return
+	}
+	// remove the searchers of C modules and all-in-one loaders
+	for i := int64(5); i <= n; i++ {
+		L.RawGeti(searchers, i)
+		L.RawSeti(searchers, i-2)
+	}
+	L.PushNil()
+	L.RawSeti(searchers, n)
+	L.PushNil()
+	L.RawSeti(searchers, n-1)
+}
+
+// Searcher of package.searchers loading lua modules from the file system set with SetFS, using the templates of package.path
+func fsSearcher(L *State) int {
+	name := fsCheckString(L, 1, "searcher")
+	L.GetField(LUA_REGISTRYINDEX, "_LOADED")
+	L.GetField(-1, "package")
+	L.GetField(-1, "path")
+	if L.Type(-1) != LUA_TSTRING {
+		L.RaiseError("'package.path' must be a string")
+	}
+	templates := L.ToString(-1)
+	L.Pop(3)
+
+	filename, notFound := searchFS(L.fsys, name, templates)
+	if filename == "" {
+		L.PushString(notFound)
+		return 1
+	}
+	if L.loadFS(filename, "bt") != 0 {
+		L.RaiseError(fmt.Sprintf("error loading module '%s' from file '%s':\n\t%s", name, filename, L.ToString(-1)))
+	}
+	L.PushString(filename)
+	return 2
+}
+
+// Returns the first file of fsys matching name in the ';' separated templates,
+// or an empty string and the list of files tried, formatted like package.searchpath does
+func searchFS(fsys fs.FS, name string, templates string) (string, string) {
+	name = strings.Replace(name, ".", "/", -1)
+	var notFound strings.Builder
+	for _, template := range strings.Split(templates, ";") {
+		if template == "" {
+			continue
+		}
+		filename := strings.Replace(template, "?", name, -1)
+		if info, err := fs.Stat(fsys, fsName(filename)); err == nil && !info.IsDir() {
+			return filename, ""
+		}
+		notFound.WriteString("\n\tno file '" + filename + "'")
+	}
+	return "", notFound.String()
+}
+
+// Returns the name of filename inside a fs.FS, where paths are always unrooted
+func fsName(filename string) string {
+	name := strings.TrimPrefix(path.Clean("/"+filename), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// Like luaL_loadfilex but reading filename from the file system set with SetFS
+func (L *State) loadFS(filename string, mode string) int {
+	buf, err := fs.ReadFile(L.fsys, fsName(filename))
+	if err != nil {
+		if perr, ok := err.(*fs.PathError); ok {
+			err = perr.Err
+		}
+		L.PushString(fmt.Sprintf("cannot open %s: %v", filename, err))
+		return LUA_ERRFILE
+	}
+	if len(buf) > 0 && buf[0] == '#' {
+		// skip the first line, keeping its newline so that line numbers are preserved
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			buf = buf[i:]
+		} else {
+			buf = nil
+		}
+	}
+	return L.LoadBuffer(buf, "@"+filename, mode)
+}
+
+// Returns the string argument narg of the function fname, raises an error if it is not a string
+func fsCheckString(L *State, narg int, fname string) string {
+	if L.Type(narg) != LUA_TSTRING {
+		L.RaiseError(fmt.Sprintf("bad argument #%d to '%s' (string expected, got %s)", narg, fname, L.Typename(int(L.Type(narg)))))
+	}
+	return L.ToString(narg)
+}
+
+// loadfile ([filename [, mode [, env]]])
+func fsLoadfile(L *State) int {
+	filename := fsCheckString(L, 1, "loadfile")
+	mode := "bt"
+	if !L.IsNoneOrNil(2) {
+		mode = fsCheckString(L, 2, "loadfile")
+	}
+	hasEnv := L.Type(3) != LUA_TNONE
+	if L.loadFS(filename, mode) != 0 {
+		L.PushNil()
+		L.Insert(-2)
+		return 2
+	}
+	if hasEnv {
+		L.PushValue(3)
+		C.clua_setchunkenv(L.state(), -2)
+	}
+	return 1
+}
+
+// dofile ([filename])
+func fsDofile(L *State) int {
+	filename := fsCheckString(L, 1, "dofile")
+	L.SetTop(1)
+	if L.loadFS(filename, "bt") != 0 {
+		L.RaiseError(L.ToString(-1))
+	}
+	L.MustCall(0, LUA_MULTRET)
+	return L.GetTop() - 1
+}
+
+// Opens filename from the file system set with SetFS as a read-only file handle.
+// On failure pushes nil followed by an error message and returns false.
+func (L *State) openFS(filename string) bool {
+	f, err := L.fsys.Open(fsName(filename))
+	if err == nil {
+		st := newGoStream(f)
+		// fs.File is read-only even if the underlying value implements io.Writer
+		st.w = nil
+		if L.pushGoStream(st, true) {
+			return true
+		}
+		L.Pop(1)
+		f.Close()
+		err = fmt.Errorf("cannot create file handle")
+	}
+	if perr, ok := err.(*fs.PathError); ok {
+		err = perr.Err
+	}
+	L.PushNil()
+	L.PushString(fmt.Sprintf("%s: %v", filename, err))
+	return false
+}
+
+// io.open (filename [, mode])
+func fsOpen(L *State) int {
+	filename := fsCheckString(L, 1, "open")
+	mode := "r"
+	if !L.IsNoneOrNil(2) {
+		mode = fsCheckString(L, 2, "open")
+	}
+	if mode != "r" && mode != "rb" {
+		L.PushNil()
+		L.PushString(filename + ": file system is read-only")
+		return 2
+	}
+	if !L.openFS(filename) {
+		return 2
+	}
+	return 1
+}
+
+// io.lines ([filename, ...])
+func fsLines(L *State) int {
+	if L.IsNoneOrNil(1) {
+		// lines of the default input file
+		L.PushValue(L.UpvalueIndex(1))
+		L.Insert(1)
+		L.MustCall(L.GetTop()-1, LUA_MULTRET)
+		return L.GetTop()
+	}
+	filename := fsCheckString(L, 1, "lines")
+	if !L.openFS(filename) {
+		L.RaiseError(L.ToString(-1))
+	}
+	L.Replace(1)
+	// iterator returned by file:lines(...)
+	L.GetField(1, "lines")
+	L.Insert(1)
+	L.PushValue(2)
+	L.Insert(1)
+	L.MustCall(L.GetTop()-2, 1)
+	L.Insert(1)
+	L.PushGoClosureN(fsLinesIterator, 2)
+	return 1
+}
+
+// Iterator returned by io.lines, closes the file once the end of the file is reached
+func fsLinesIterator(L *State) int {
+	L.SetTop(0)
+	L.PushValue(L.UpvalueIndex(1))
+	L.MustCall(0, LUA_MULTRET)
+	if L.IsNil(1) {
+		L.GetField(L.UpvalueIndex(2), "close")
+		L.PushValue(L.UpvalueIndex(2))
+		L.MustCall(1, 0)
+	}
+	return L.GetTop()
+}
@@ -0,0 +1,43 @@
+package lua
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+	L.SetFS(fstest.MapFS{
+		"main.lua":     {Data: []byte("#!/usr/bin/lua\nreturn require('lib.util').answer")},
+		"lib/util.lua": {Data: []byte("return {answer = 42}")},
+		"data.txt":     {Data: []byte("first\nsecond\n")},
+		"env.lua":      {Data: []byte("return answer")},
+	})
+
+	if err := L.DoFile("/main.lua"); err != nil {
+		t.Fatalf("DoFile failed: %v", err)
+	}
+	if L.ToInteger(-1) != 42 {
+		t.Fatalf("Wrong value returned by DoFile: %v", L.ToInteger(-1))
+	}
+	L.Pop(1)
+
+	checks := []string{
+		"assert(dofile('main.lua') == 42)",
+		"assert(loadfile('lib/util.lua')().answer == 42)",
+		"assert(loadfile('env.lua', 't', {answer = 7})() == 7 and answer == nil)",
+		"local f, err = loadfile('missing.lua'); assert(f == nil and err:find('missing.lua'))",
+		"local ok, err = unsafe_pcall(require, 'missing'); assert(not ok and err:find(\"no file './missing.lua'\", 1, true))",
+		"local f = assert(io.open('data.txt')); assert(f:read('l') == 'first'); assert(f:read('a') == 'second\\n'); f:close()",
+		"local f = io.open('data.txt'); f:seek('set', 6); assert(f:read('l') == 'second'); f:close()",
+		"assert(io.open('data.txt', 'w') == nil and io.open('missing.txt') == nil)",
+		"local t = {}; for l in io.lines('data.txt') do t[#t+1] = l end; assert(#t == 2 and t[2] == 'second')",
+	}
+	for _, code := range checks {
+		if err := L.DoString(code); err != nil {
+			t.Fatalf("Check failed <%s>: %v", code, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package lua
+
+// Performs a full garbage collection cycle
+func (L *State) CollectGarbage() {
+	L.GC(LUA_GCCOLLECT, 0)
+}
+
+// Stops the garbage collector, memory keeps growing until RestartGC is called or a cycle is run explicitly
+func (L *State) StopGC() {
+	L.GC(LUA_GCSTOP, 0)
+}
+
+// Restarts the garbage collector stopped by StopGC
+func (L *State) RestartGC() {
+	L.GC(LUA_GCRESTART, 0)
+}
+
+// Returns true if the garbage collector is running (not stopped)
+func (L *State) IsGCRunning() bool {
+	return L.GC(LUA_GCISRUNNING, 0) != 0
+}
+
+// Returns the size of the lua heap in bytes
+func (L *State) MemoryUsage() uint64 {
+	return uint64(L.GC(LUA_GCCOUNT, 0))*1024 + uint64(L.GC(LUA_GCCOUNTB, 0))
+}
+
+// Performs an incremental step of garbage collection, as if kb kilobytes had been allocated (0 performs a single basic step).
+// Returns true if the step finished a collection cycle.
+func (L *State) StepGC(kb int) bool {
+	return L.GC(LUA_GCSTEP, kb) != 0
+}
+
+// Sets the pause of the collector (how much the heap grows, in percent, before a new cycle starts) and returns the previous value
+func (L *State) SetGCPause(pause int) int {
+	return L.GC(LUA_GCSETPAUSE, pause)
+}
+
+// Sets the step multiplier of the collector (its speed relative to allocations, in percent) and returns the previous value
+func (L *State) SetGCStepMul(mul int) int {
+	return L.GC(LUA_GCSETSTEPMUL, mul)
+}
@@ -0,0 +1,38 @@
+package lua
+
+import "testing"
+
+func TestGCControl(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	if !L.IsGCRunning() {
+		t.Fatal("Collector not running in a new State")
+	}
+	L.StopGC()
+	if L.IsGCRunning() {
+		t.Fatal("Collector still running after StopGC")
+	}
+	L.MustDoString("local t = {} for i = 1, 1000 do t[i] = {} end")
+	before := L.MemoryUsage()
+	L.CollectGarbage()
+	if after := L.MemoryUsage(); after >= before {
+		t.Fatalf("Memory not collected: %d >= %d", after, before)
+	}
+	L.RestartGC()
+	if !L.IsGCRunning() {
+		t.Fatal("Collector not restarted")
+	}
+
+	prev := L.SetGCPause(150)
+	if L.SetGCPause(prev) != 150 {
+		t.Fatal("Previous pause not returned")
+	}
+	prev = L.SetGCStepMul(300)
+	if L.SetGCStepMul(prev) != 300 {
+		t.Fatal("Previous step multiplier not returned")
+	}
+	for !L.StepGC(0) {
+	}
+}
@@ -1,7 +1,7 @@
 package lua
 
 /*
-#cgo CFLAGS: -I ${SRCDIR}/lua
+#cgo !lua54,!luajit CFLAGS: -I ${SRCDIR}/lua
 
 #include <lua.h>
 #include <lualib.h>
@@ -10,8 +10,15 @@ package lua
 import "C"
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -24,10 +31,22 @@ type LuaGoFunction func(L *State) int
 // Wrapper to keep cgo from complaining about incomplete ptr type
 //export State
 type State struct {
-	// Wrapped lua_State object
+	// The State returned by NewState and the one passed to Go functions called from lua are distinct handles sharing the same data,
+	// so that the finalizer of the former can run while goStates references the latter
+	*stateData
+}
+
+type stateData struct {
+	// Wrapped lua_State object, nil once the State is closed
 	s *C.lua_State
 
-	// index of this object inside the goStates array
+	// Held while the wrapped lua_State is being closed or interrupted, so that Interrupt can't use a freed lua_State
+	closeMu sync.Mutex
+
+	// State of the main thread for States created by NewThread
+	main *State
+
+	// index of this object inside the goStates table
 	Index uintptr
 
 	// Registry of go object that have been pushed to Lua VM
@@ -35,32 +54,128 @@ type State struct {
 
 	// Freelist for funcs indices, to allow for freeing
 	freeIndices []uint
+
+	// Go call sites of the registered values, when enabled with TrackPushSites
+	pushSites []string
+
+	// Pending interruption of the running lua code
+	interrupt atomic.Pointer[interruptRequest]
+
+	// Number of nested calls currently executing
+	callDepth int
+
+	// Instruction count set with SetExecutionLimit
+	executionLimit int
+
+	// Instruction budget and instructions executed against it
+	budget     int64
+	budgetUsed int64
+	// Granularity set with SetBudgetGranularity, 0 for the default
+	budgetStepSize int
+
+	// File system set with SetFS
+	fsys fs.FS
+
+	// Writer of print, set with SetStdout
+	stdout io.Writer
+
+	// Last identifier given to a WeakRef
+	weakRefNext int64
+
+	// Handler of lua warnings and pieces of the warning being emitted (lua 5.4)
+	warnHandler func(msg string)
+	warnPieces  []byte
+}
+
+// Error raised by the methods of a State after Close
+var ErrStateClosed = errors.New("lua: use of closed State")
+
+func (L *State) isClosed() bool {
+	return L.s == nil || (L.main != nil && L.main.s == nil)
+}
+
+// Returns the wrapped lua_State, panics with ErrStateClosed if L was closed
+func (L *State) state() *C.lua_State {
+	if L.isClosed() {
+		panic(ErrStateClosed)
+	}
+	return L.s
+}
+
+// Returns the State owning the lua heap of L: L itself, or its main State if L was created by NewThread
+func (L *State) root() *State {
+	if L.main != nil {
+		return L.main
+	}
+	return L
 }
 
-var goStates map[uintptr]*State
+// Table of the live States indexed by State.Index, replaced as a whole on every change so that callbacks can look States up without locking.
+// The entries are internal handles, never the State returned by NewState, so that the finalizer of a leaked State can run.
+// Index 0 is never used.
+var goStates atomic.Pointer[[]*State]
+
+// Serializes the updates of goStates and goStatesFree
 var goStatesMutex sync.Mutex
 
+// Indices of goStates freed by closed States
+var goStatesFree []uintptr
+
 func init() {
-	goStates = make(map[uintptr]*State, 16)
+	goStates.Store(&[]*State{nil})
+}
+
+// Replaces goStates by a copy where the entry at index is L, must be called with goStatesMutex held
+func setGoState(index uintptr, L *State) {
+	old := *goStates.Load()
+	states := make([]*State, len(old), len(old)+1)
+	copy(states, old)
+	if index == uintptr(len(old)) {
+		states = append(states, nil)
+	}
+	states[index] = L
+	goStates.Store(&states)
 }
 
+// Registers a new handle on the data of L in goStates
 func registerGoState(L *State) {
 	goStatesMutex.Lock()
 	defer goStatesMutex.Unlock()
-	L.Index = uintptr(unsafe.Pointer(L))
-	goStates[L.Index] = L
+	index := uintptr(len(*goStates.Load()))
+	if n := len(goStatesFree); n > 0 {
+		index = goStatesFree[n-1]
+		goStatesFree = goStatesFree[:n-1]
+	}
+	L.Index = index
+	setGoState(index, &State{L.stateData})
 }
 
 func unregisterGoState(L *State) {
 	goStatesMutex.Lock()
 	defer goStatesMutex.Unlock()
-	delete(goStates, L.Index)
+	old := *goStates.Load()
+	if L.Index == 0 || L.Index >= uintptr(len(old)) || old[L.Index] == nil || old[L.Index].stateData != L.stateData {
+		return
+	}
+	setGoState(L.Index, nil)
+	goStatesFree = append(goStatesFree, L.Index)
 }
 
 func getGoState(gostateindex uintptr) *State {
-	goStatesMutex.Lock()
-	defer goStatesMutex.Unlock()
-	return goStates[gostateindex]
+	states := *goStates.Load()
+	if gostateindex >= uintptr(len(states)) {
+		return nil
+	}
+	return states[gostateindex]
+}
+
+// Finalizer of the States returned by NewState, closes the States that were not closed by their owner
+func finalizeState(L *State) {
+	if L.s == nil {
+		return
+	}
+	log.Printf("lua: State %d garbage collected without being closed", L.Index)
+	L.Close()
 }
 
 //export golua_callgofunction
@@ -89,12 +204,12 @@ func golua_interface_newindex_callback(gostateindex uintptr, iid uint, field_nam
 		fval = fval.Elem()
 	}
 
-	luatype := LuaValType(C.lua_type(L.s, 3))
+	luatype := LuaValType(C.lua_type(L.state(), 3))
 
 	switch fval.Kind() {
 	case reflect.Bool:
 		if luatype == LUA_TBOOLEAN {
-			fval.SetBool(int(C.lua_toboolean(L.s, 3)) != 0)
+			fval.SetBool(int(C.lua_toboolean(L.state(), 3)) != 0)
 			return 1
 		} else {
 			L.PushString("Wrong assignment to field " + field_name)
@@ -111,7 +226,16 @@ func golua_interface_newindex_callback(gostateindex uintptr, iid uint, field_nam
 		fallthrough
 	case reflect.Int64:
 		if luatype == LUA_TNUMBER {
-			fval.SetInt(int64(C.lua_tointegerx(L.s, 3, nil)))
+			n, ok := L.ToIntegerX(3)
+			if !ok {
+				L.PushString("Wrong assignment to field " + field_name + ": number has no integer representation")
+				return -1
+			}
+			if fval.OverflowInt(n) {
+				L.PushString(fmt.Sprintf("Wrong assignment to field %s: %d overflows %s", field_name, n, fval.Type()))
+				return -1
+			}
+			fval.SetInt(n)
 			return 1
 		} else {
 			L.PushString("Wrong assignment to field " + field_name)
@@ -128,7 +252,12 @@ func golua_interface_newindex_callback(gostateindex uintptr, iid uint, field_nam
 		fallthrough
 	case reflect.Uint64:
 		if luatype == LUA_TNUMBER {
-			fval.SetUint(uint64(C.lua_tointegerx(L.s, 3, nil)))
+			n, ok := L.toUint64(3)
+			if !ok || fval.OverflowUint(n) {
+				L.PushString(fmt.Sprintf("Wrong assignment to field %s: %s overflows %s", field_name, L.ToString(3), fval.Type()))
+				return -1
+			}
+			fval.SetUint(n)
 			return 1
 		} else {
 			L.PushString("Wrong assignment to field " + field_name)
@@ -137,7 +266,7 @@ func golua_interface_newindex_callback(gostateindex uintptr, iid uint, field_nam
 
 	case reflect.String:
 		if luatype == LUA_TSTRING {
-			fval.SetString(C.GoString(C.lua_tolstring(L.s, 3, nil)))
+			fval.SetString(C.GoString(C.lua_tolstring(L.state(), 3, nil)))
 			return 1
 		} else {
 			L.PushString("Wrong assignment to field " + field_name)
@@ -148,7 +277,12 @@ func golua_interface_newindex_callback(gostateindex uintptr, iid uint, field_nam
 		fallthrough
 	case reflect.Float64:
 		if luatype == LUA_TNUMBER {
-			fval.SetFloat(float64(C.lua_tonumberx(L.s, 3, nil)))
+			n := L.ToNumber(3)
+			if fval.OverflowFloat(n) {
+				L.PushString(fmt.Sprintf("Wrong assignment to field %s: %g overflows %s", field_name, n, fval.Type()))
+				return -1
+			}
+			fval.SetFloat(n)
 			return 1
 		} else {
 			L.PushString("Wrong assignment to field " + field_name)
@@ -170,6 +304,20 @@ func golua_interface_newindex_callback(gostateindex uintptr, iid uint, field_nam
 	return -1
 }
 
+// Converts the number at index to an uint64, ok is false if it is negative, too large or has no integer representation.
+// Floats are accepted up to 2^64, above the largest lua integer.
+func (L *State) toUint64(index int) (n uint64, ok bool) {
+	if L.IsInteger(index) {
+		i := L.ToInteger(index)
+		return uint64(i), i >= 0
+	}
+	f := L.ToNumber(index)
+	if f >= 0 && f < 1<<64 && f == math.Trunc(f) {
+		return uint64(f), true
+	}
+	return 0, false
+}
+
 //export golua_interface_index_callback
 func golua_interface_index_callback(gostateindex uintptr, iid uint, field_name *C.char) int {
 	L := getGoState(gostateindex)
@@ -208,7 +356,13 @@ func golua_interface_index_callback(gostateindex uintptr, iid uint, field_name *
 	case reflect.Uint32:
 		fallthrough
 	case reflect.Uint64:
-		L.PushInteger(int64(fval.Uint()))
+		// values that don't fit in a lua integer are not rounded to a float, which could not be assigned back
+		n := fval.Uint()
+		if n > math.MaxInt64 {
+			L.PushString(fmt.Sprintf("Value of field %s overflows a lua integer: %d", C.GoString(field_name), n))
+			return -1
+		}
+		L.PushInteger(int64(n))
 		return 1
 
 	case reflect.String:
@@ -231,6 +385,88 @@ func golua_interface_index_callback(gostateindex uintptr, iid uint, field_name *
 	return -1
 }
 
+//export golua_warn
+func golua_warn(gostateindex uintptr, msg *C.char, tocont C.int) {
+	L := getGoState(gostateindex)
+	if L == nil || L.warnHandler == nil {
+		return
+	}
+	L.warnPieces = append(L.warnPieces, C.GoString(msg)...)
+	if tocont != 0 {
+		return
+	}
+	full := string(L.warnPieces)
+	L.warnPieces = L.warnPieces[:0]
+	L.warnHandler(full)
+}
+
+//export golua_budgethook
+func golua_budgethook(gostateindex uintptr, count int) int {
+	L := getGoState(gostateindex)
+	if L.interrupt.Load() != nil {
+		return -1
+	}
+	if L.budget <= 0 {
+		return budgetGranularity
+	}
+	if L.budgetUsed < L.budget {
+		L.budgetUsed += int64(count)
+	}
+	return L.budgetStep()
+}
+
+//export golua_streamread
+func golua_streamread(gostateindex uintptr, id uint, buf unsafe.Pointer, size int) int {
+	L := getGoState(gostateindex)
+	st := L.registry[id].(*goStream)
+	if st.r == nil {
+		return -1
+	}
+	n, err := io.ReadAtLeast(st.r, unsafe.Slice((*byte)(buf), size), 1)
+	if n == 0 && err != nil && err != io.EOF {
+		return -1
+	}
+	return n
+}
+
+//export golua_streamwrite
+func golua_streamwrite(gostateindex uintptr, id uint, buf unsafe.Pointer, size int) int {
+	L := getGoState(gostateindex)
+	st := L.registry[id].(*goStream)
+	if st.w == nil {
+		return -1
+	}
+	if _, err := st.w.Write(unsafe.Slice((*byte)(buf), size)); err != nil {
+		return -1
+	}
+	return size
+}
+
+//export golua_streamseek
+func golua_streamseek(gostateindex uintptr, id uint, offset int64, whence int) int64 {
+	L := getGoState(gostateindex)
+	st := L.registry[id].(*goStream)
+	if st.s == nil {
+		return -1
+	}
+	pos, err := st.s.Seek(offset, whence)
+	if err != nil {
+		return -1
+	}
+	return pos
+}
+
+//export golua_streamclose
+func golua_streamclose(gostateindex uintptr, id uint) int {
+	L := getGoState(gostateindex)
+	st := L.registry[id].(*goStream)
+	L.unregister(id)
+	if st.c != nil && st.c.Close() != nil {
+		return -1
+	}
+	return 0
+}
+
 //export golua_gchook
 func golua_gchook(gostateindex uintptr, id uint) int {
 	L1 := getGoState(gostateindex)
@@ -1,8 +1,13 @@
 #include <stdint.h>
+#include "golua_compat.h"
 
 typedef struct { void *t; void *v; } GoInterface;
 
 #define GOLUA_DEFAULT_MSGHANDLER "golua_default_msghandler"
+#define GOLUA_INTERRUPT_MSG "lua execution interrupted"
+#define GOLUA_BUDGET_MSG "lua instruction budget exceeded"
+#define GOLUA_INTERRUPTED 1
+#define GOLUA_BUDGET_EXCEEDED 2
 
 /* function to setup metatables, etc */
 void clua_initstate(lua_State* L);
@@ -11,6 +16,7 @@ void clua_hide_pcall(lua_State *L);
 unsigned int clua_togofunction(lua_State* L, int index);
 unsigned int clua_togostruct(lua_State *L, int index);
 void clua_pushcallback(lua_State* L);
+void clua_pushgoclosure(lua_State* L, unsigned int fid, int n);
 void clua_pushgofunction(lua_State* L, unsigned int fid);
 void clua_pushgostruct(lua_State *L, unsigned int fid);
 void clua_setgostate(lua_State* L, size_t gostateindex);
@@ -21,6 +27,10 @@ GoInterface clua_atpanic(lua_State* L, unsigned int panicf_id);
 int clua_callluacfunc(lua_State* L, lua_CFunction f);
 lua_State* clua_newstate(void* goallocf);
 void clua_setallocf(lua_State* L, void* goallocf);
+lua_State* clua_newstatelimited(size_t limit);
+int clua_setmemlimit(lua_State* L, size_t limit);
+int clua_getmemstats(lua_State* L, size_t* limit, size_t* current, size_t* peak, size_t* allocations);
+void clua_close(lua_State* L);
 
 void clua_openbase(lua_State* L);
 void clua_openio(lua_State* L);
@@ -33,6 +43,32 @@ void clua_opencoroutine(lua_State *L);
 void clua_opendebug(lua_State *L);
 void clua_openbit32(lua_State *L);
 void clua_setexecutionlimit(lua_State* L, int n);
+void clua_interrupt(lua_State* L);
+void clua_setbudgethook(lua_State* L, int count);
+int clua_interrupterror(lua_State *L, int index);
+int clua_pushgostream(lua_State *L, unsigned int id, const char *mode, int closable);
+int clua_hasgostream(void);
+int clua_getfieldn(lua_State *L, int index, const char *k, size_t len);
+void clua_setfieldn(lua_State *L, int index, const char *k, size_t len);
+int clua_getglobaln(lua_State *L, const char *name, size_t len);
+void clua_setglobaln(lua_State *L, const char *name, size_t len);
+void clua_pushnumbers(lua_State *L, const lua_Number *v, size_t n);
+void clua_pushintegers(lua_State *L, const lua_Integer *v, size_t n);
+void clua_pushstrings(lua_State *L, const char *buf, const size_t *lens, size_t n);
+void clua_pushstringmap(lua_State *L, const char *buf, const size_t *lens, size_t n);
+size_t clua_tonumbers(lua_State *L, int index, lua_Number *out, size_t n);
+size_t clua_tointegers(lua_State *L, int index, lua_Integer *out, size_t n);
+size_t clua_stringlens(lua_State *L, int index, size_t *lens, size_t n, size_t *total);
+void clua_copystrings(lua_State *L, int index, char *buf, size_t n);
+int clua_gc(lua_State *L, int what, int data);
+void *clua_newuserdata(lua_State *L, size_t size);
+int clua_resume(lua_State *L, lua_State *from, int narg, int *nres);
+int clua_setchunkenv(lua_State *L, int index);
+#if LUA_VERSION_NUM >= 504
+int clua_gcgen(lua_State *L, int minormul, int majormul);
+int clua_gcinc(lua_State *L, int pause, int stepmul, int stepsize);
+void clua_setwarnf(lua_State *L, int enable);
+#endif
 
 int clua_isgofunction(lua_State *L, int n);
 int clua_isgostruct(lua_State *L, int n);
@@ -0,0 +1,163 @@
+/* shims providing the parts of the lua 5.3 API used by golua on top of the
+ * lua 5.1 API of LuaJIT, so that the same calls work with every backend */
+#ifndef GOLUA_COMPAT_H
+#define GOLUA_COMPAT_H
+
+#include <stddef.h>
+#include <stdint.h>
+
+#if LUA_VERSION_NUM < 502
+
+#include <luajit.h>
+
+/* golua stores the main thread and the globals in the registry at the same
+ * indices as lua 5.3, see clua_initstate */
+#define LUA_RIDX_MAINTHREAD 1
+#define LUA_RIDX_GLOBALS 2
+#define LUA_RIDX_LAST LUA_RIDX_GLOBALS
+
+#define LUA_OPEQ 0
+#define LUA_OPLT 1
+
+#ifndef LUA_OK
+#define LUA_OK 0
+#endif
+
+/* pseudo index below which upvalues are found */
+#define GOLUA_UPVALUEBASE LUA_GLOBALSINDEX
+/* name of the field of the package library holding the searchers */
+#define GOLUA_SEARCHERS "loaders"
+
+typedef intptr_t lua_KContext;
+typedef int (*lua_KFunction)(lua_State *L, int status, lua_KContext ctx);
+
+static inline int lua_absindex(lua_State *L, int idx)
+{
+	return (idx > 0 || idx <= LUA_REGISTRYINDEX) ? idx : lua_gettop(L) + idx + 1;
+}
+
+static inline size_t lua_rawlen(lua_State *L, int idx)
+{
+	return lua_objlen(L, idx);
+}
+
+static inline int lua_compare(lua_State *L, int idx1, int idx2, int op)
+{
+	switch (op)
+	{
+	case LUA_OPEQ:
+		return lua_equal(L, idx1, idx2);
+	case LUA_OPLT:
+		return lua_lessthan(L, idx1, idx2);
+	}
+	return 0;
+}
+
+/* numbers are doubles in LuaJIT, those with an exact integer value are
+ * reported as integers */
+static inline int lua_isinteger(lua_State *L, int idx)
+{
+	lua_Number n;
+	if (lua_type(L, idx) != LUA_TNUMBER)
+		return 0;
+	n = lua_tonumber(L, idx);
+	return n >= (lua_Number)PTRDIFF_MIN && n < -(lua_Number)PTRDIFF_MIN && n == (lua_Number)(lua_Integer)n;
+}
+
+static inline void golua_reverse(lua_State *L, int a, int b)
+{
+	for (; a < b; a++, b--)
+	{
+		lua_pushvalue(L, a);
+		lua_pushvalue(L, b);
+		lua_replace(L, a);
+		lua_replace(L, b);
+	}
+}
+
+/* same algorithm as lua 5.3 */
+static inline void lua_rotate(lua_State *L, int idx, int n)
+{
+	int t = lua_gettop(L);
+	int p = lua_absindex(L, idx);
+	int m = n >= 0 ? t - n : p - n - 1;
+	golua_reverse(L, p, m);
+	golua_reverse(L, m + 1, t);
+	golua_reverse(L, p, t);
+}
+
+/* continuations don't exist in lua 5.1, golua never passes one */
+static inline int lua_pcallk(lua_State *L, int nargs, int nresults, int errfunc, lua_KContext ctx, lua_KFunction k)
+{
+	(void)ctx;
+	(void)k;
+	return lua_pcall(L, nargs, nresults, errfunc);
+}
+
+static inline int lua_yieldk(lua_State *L, int nresults, lua_KContext ctx, lua_KFunction k)
+{
+	(void)ctx;
+	(void)k;
+	return lua_yield(L, nresults);
+}
+
+static inline int luaL_getsubtable(lua_State *L, int idx, const char *fname)
+{
+	lua_getfield(L, idx, fname);
+	if (lua_istable(L, -1))
+		return 1;
+	lua_pop(L, 1);
+	idx = lua_absindex(L, idx);
+	lua_newtable(L);
+	lua_pushvalue(L, -1);
+	lua_setfield(L, idx, fname);
+	return 0;
+}
+
+static inline void luaL_requiref(lua_State *L, const char *modname, lua_CFunction openf, int glb)
+{
+	lua_pushcfunction(L, openf);
+	lua_pushstring(L, modname);
+	lua_call(L, 1, 1);
+	luaL_getsubtable(L, LUA_REGISTRYINDEX, "_LOADED");
+	lua_pushvalue(L, -2);
+	lua_setfield(L, -2, modname);
+	lua_pop(L, 1);
+	if (glb)
+	{
+		lua_pushvalue(L, -1);
+		lua_setglobal(L, modname);
+	}
+}
+
+#else
+
+#define GOLUA_UPVALUEBASE LUA_REGISTRYINDEX
+#define GOLUA_SEARCHERS "searchers"
+
+#endif
+
+/* lua_rawgeti and lua_rawseti take an int key in lua 5.1 */
+static inline void clua_rawgeti(lua_State *L, int idx, lua_Integer n)
+{
+	lua_rawgeti(L, idx, n);
+}
+
+static inline void clua_rawseti(lua_State *L, int idx, lua_Integer n)
+{
+	lua_rawseti(L, idx, n);
+}
+
+/* the lua_tointegerx of LuaJIT truncates floats, they are only converted if
+ * they have an exact integer value like in lua 5.3 */
+static inline lua_Integer clua_tointegerx(lua_State *L, int idx, int *isnum)
+{
+	lua_Integer n = lua_tointegerx(L, idx, isnum);
+#if LUA_VERSION_NUM < 502
+	if (isnum != NULL && *isnum && (lua_Number)n != lua_tonumber(L, idx))
+		*isnum = 0;
+#endif
+	return n;
+}
+
+#endif
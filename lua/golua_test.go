@@ -0,0 +1,76 @@
+package lua
+
+import "testing"
+
+func TestGoStatesReuse(t *testing.T) {
+	L1 := NewState()
+	index := L1.Index
+	L1.Close()
+	L1.Close()
+
+	L2 := NewState()
+	defer L2.Close()
+	if L2.Index != index {
+		t.Fatalf("Index of closed State not reused: %d != %d", L2.Index, index)
+	}
+	if getGoState(L2.Index).stateData != L2.stateData || getGoState(0) != nil {
+		t.Fatal("Wrong State returned by getGoState")
+	}
+}
+
+func BenchmarkGoCallback(b *testing.B) {
+	L := NewState()
+	defer L.Close()
+	L.Register("f", func(L *State) int {
+		L.PushInteger(L.ToInteger(1) + 1)
+		return 1
+	})
+	L.PushInteger(int64(b.N))
+	L.SetGlobal("n")
+	b.ResetTimer()
+	if err := L.DoString("local x = 0; for i = 1, n do x = f(x) end"); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// Go callbacks on one State per goroutine, scales with GOMAXPROCS (run with -cpu 1,2,4,8)
+func BenchmarkGoCallbackParallel(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		L := NewState()
+		defer L.Close()
+		L.Register("f", func(L *State) int {
+			L.PushInteger(L.ToInteger(1) + 1)
+			return 1
+		})
+		L.MustDoString("function loop(n) local x = 0; for i = 1, n do x = f(x) end end")
+		for pb.Next() {
+			L.GetGlobal("loop")
+			L.PushInteger(100)
+			L.MustCall(1, 0)
+		}
+	})
+}
+
+func TestClose(t *testing.T) {
+	L := NewState()
+	L.OpenLibs()
+	L.Register("f", func(L *State) int { return 0 })
+	T := L.NewThread()
+	L.Close()
+	L.Close()
+
+	if L.registry != nil {
+		t.Fatal("Go references kept after Close")
+	}
+	if err := L.DoString("f()"); err != ErrStateClosed {
+		t.Fatalf("DoString on closed State returned %v", err)
+	}
+
+	defer func() {
+		if r := recover(); r != ErrStateClosed {
+			t.Fatalf("Wrong panic on closed thread: %v", r)
+		}
+	}()
+	T.GetTop()
+	t.Fatal("No panic on closed thread")
+}
@@ -0,0 +1,179 @@
+package lua
+
+//#include <lua.h>
+//#include <lauxlib.h>
+//#include <stdlib.h>
+//#include "golua.h"
+import "C"
+
+import (
+	"context"
+	"errors"
+)
+
+// The reason of the interruption when Interrupt is called with a nil reason
+var ErrInterrupted = errors.New("interrupted")
+
+// Error returned when the execution of lua code is interrupted before completion, by Interrupt or by the cancellation of the context passed to CallContext.
+type InterruptError struct {
+	*LuaError
+	// Why the execution was interrupted, for CallContext this is the error returned by ctx.Err()
+	Reason error
+}
+
+func (err *InterruptError) Error() string {
+	return C.GOLUA_INTERRUPT_MSG + ": " + err.Reason.Error()
+}
+
+func (err *InterruptError) Unwrap() error {
+	return err.Reason
+}
+
+type interruptRequest struct {
+	reason error
+}
+
+// Stops the execution of the lua code running on L, unlike every other method of State it can be called from any goroutine.
+//
+// The running code raises an error at its next instruction, that can't be caught by lua code, and the call executing it (Call, DoString, ...)
+// returns an InterruptError wrapping reason. If no lua code is running the interruption is delivered to the next call.
+func (L *State) Interrupt(reason error) {
+	if reason == nil {
+		reason = ErrInterrupted
+	}
+	L.interruptWith(reason)
+}
+
+// Requests the interruption of the running lua code, the request stays pending until it is reported by a call.
+func (L *State) interruptWith(reason error) *interruptRequest {
+	req := &interruptRequest{reason}
+	L.interrupt.Store(req)
+	// Close can run concurrently on the goroutine owning L
+	root := L.root()
+	root.closeMu.Lock()
+	if root.s != nil {
+		C.clua_interrupt(L.s)
+	}
+	root.closeMu.Unlock()
+	return req
+}
+
+// Withdraws req if it is still pending
+func (L *State) clearInterrupt(req *interruptRequest) {
+	if L.interrupt.CompareAndSwap(req, nil) {
+		L.resetHook()
+	}
+}
+
+// Installs the hook required by the instruction budget or the execution limit, unless an interruption is pending.
+//
+// Without budget nor execution limit the budget hook is installed anyway, it polls for interruptions so that
+// coroutines, which inherit the hook but not the one installed by Interrupt on the main thread, are interrupted too.
+func (L *State) resetHook() {
+	if L.interrupt.Load() != nil {
+		return
+	}
+	switch {
+	case L.budget > 0:
+		step := L.budgetStep()
+		if step <= 0 {
+			// let the hook report the exhausted budget at the first instruction
+			step = 1
+		}
+		C.clua_setbudgethook(L.state(), C.int(step))
+	case L.executionLimit > 0:
+		C.clua_setexecutionlimit(L.state(), C.int(L.executionLimit))
+	default:
+		C.clua_setbudgethook(L.state(), C.int(budgetGranularity))
+	}
+	// an Interrupt landing before the hook was installed had its hook overwritten
+	if L.interrupt.Load() != nil {
+		C.clua_interrupt(L.state())
+	}
+}
+
+func (L *State) enterCall() {
+	L.callDepth++
+	if L.callDepth > 1 {
+		return
+	}
+	if L.interrupt.Load() != nil {
+		// the hook could have been removed by the end of the previous call
+		C.clua_interrupt(L.state())
+	} else {
+		L.resetHook()
+	}
+}
+
+func (L *State) leaveCall(err error) {
+	L.callDepth--
+	if L.callDepth > 0 {
+		return
+	}
+	if ierr, ok := err.(*InterruptError); ok {
+		if req := L.interrupt.Load(); req != nil && req.reason == ierr.Reason {
+			L.clearInterrupt(req)
+		}
+	}
+}
+
+// Converts the error value at the top of the stack, left by a failed protected call returning r, into an error,
+// the values raised by the interruption hooks become an InterruptError
+func (L *State) callError(r int) error {
+	switch C.clua_interrupterror(L.state(), -1) {
+	case C.GOLUA_BUDGET_EXCEEDED:
+		return &InterruptError{&LuaError{r, C.GOLUA_BUDGET_MSG, L.StackTrace()}, ErrBudgetExceeded}
+	case C.GOLUA_INTERRUPTED:
+		le := &LuaError{r, C.GOLUA_INTERRUPT_MSG, L.StackTrace()}
+		if req := L.interrupt.Load(); req != nil {
+			return &InterruptError{le, req.reason}
+		}
+		return le
+	}
+	return &LuaError{r, L.ToString(-1), L.StackTrace()}
+}
+
+// Like Call but interrupts the execution of lua code when ctx is cancelled or its deadline expires, in which case an InterruptError wrapping ctx.Err() is returned.
+//
+// The State remains usable after the interruption. Like Call it leaves the error message on the stack, also when ctx is done before the call.
+// Lua code running inside a coroutine is interrupted within a thousand instructions, by the hook it inherited from the main thread.
+func (L *State) CallContext(ctx context.Context, nargs, nresults int) error {
+	if err := ctx.Err(); err != nil {
+		// leave the error message in place of the function, like an interruption during the call
+		L.Pop(nargs + 1)
+		L.PushString(C.GOLUA_INTERRUPT_MSG)
+		return &InterruptError{&LuaError{LUA_ERRRUN, C.GOLUA_INTERRUPT_MSG, nil}, err}
+	}
+	if ctx.Done() == nil {
+		return L.Call(nargs, nresults)
+	}
+
+	var req *interruptRequest
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			req = L.interruptWith(ctx.Err())
+		case <-stop:
+		}
+	}()
+
+	err := L.Call(nargs, nresults)
+	close(stop)
+	<-done
+	if req != nil {
+		// the context could have been cancelled after the lua code returned
+		L.clearInterrupt(req)
+	}
+	return err
+}
+
+// Like DoString but interrupts the execution of lua code when ctx is cancelled or its deadline expires, see CallContext.
+func (L *State) DoStringContext(ctx context.Context, str string) error {
+	if r := L.LoadString(str); r != 0 {
+		return &LuaError{r, L.ToString(-1), L.StackTrace()}
+	}
+	return L.CallContext(ctx, 0, LUA_MULTRET)
+}
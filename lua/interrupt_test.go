@@ -0,0 +1,131 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDoStringContext(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := L.DoStringContext(ctx, "while true do end")
+		cancel()
+		if err == nil {
+			t.Fatal("Infinite loop was not interrupted")
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Wrong error returned by an interrupted call: %v", err)
+		}
+		if _, ok := err.(*InterruptError); !ok {
+			t.Fatalf("Interrupted call did not return an InterruptError: %T", err)
+		}
+		L.SetTop(0)
+	}
+
+	if err := L.DoStringContext(context.Background(), "return 1"); err != nil {
+		t.Fatalf("State not usable after an interruption: %v", err)
+	}
+	if L.ToInteger(-1) != 1 {
+		t.Fatal("Wrong return value after an interruption")
+	}
+	L.SetTop(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := L.DoStringContext(ctx, "return 1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Call with cancelled context did not return context.Canceled: %v", err)
+	}
+	if L.GetTop() != 1 {
+		t.Fatalf("Call with cancelled context did not leave the error on the stack: %d", L.GetTop())
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	stop := errors.New("watchdog")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		L.Interrupt(stop)
+	}()
+
+	err := L.DoString("while true do unsafe_pcall(function() while true do end end) end")
+	if !errors.Is(err, stop) {
+		t.Fatalf("Wrong error returned by an interrupted call: %v", err)
+	}
+	L.SetTop(0)
+
+	// interruptions requested while no code is running are delivered to the next call
+	L.Interrupt(nil)
+	if err := L.DoString("return 1"); !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("Pending interruption not delivered: %v", err)
+	}
+	L.SetTop(0)
+
+	if err := L.DoString("return 1"); err != nil {
+		t.Fatalf("State not usable after an interruption: %v", err)
+	}
+}
+
+func TestFakeInterruptError(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	for _, msg := range []string{"lua execution interrupted", "lua instruction budget exceeded"} {
+		err := L.DoString("error('" + msg + "', 0)")
+		var ierr *InterruptError
+		if err == nil || errors.As(err, &ierr) {
+			t.Fatalf("Lua code faked an interruption with %q: %v", msg, err)
+		}
+		L.SetTop(0)
+	}
+}
+
+func TestInterruptClose(t *testing.T) {
+	L := NewState()
+	L.OpenLibs()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			L.Interrupt(nil)
+		}
+	}()
+	L.Close()
+	<-done
+}
+
+func TestInterruptCoroutine(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := L.DoStringContext(ctx, "coroutine.wrap(function() while true do end end)()")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Loop running inside a coroutine was not interrupted: %v", err)
+	}
+	L.SetTop(0)
+
+	// the coroutine catching the interruption doesn't stop it either
+	stop := errors.New("watchdog")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		L.Interrupt(stop)
+	}()
+	err = L.DoString("local co = coroutine.create(function() while true do end end) while true do coroutine.resume(co) end")
+	if !errors.Is(err, stop) {
+		t.Fatalf("Loop resuming a coroutine was not interrupted: %v", err)
+	}
+}
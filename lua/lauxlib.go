@@ -32,7 +32,7 @@ func (L *State) Argcheck(cond bool, narg int, extramsg string) {
 	if !cond {
 		Cextramsg := C.CString(extramsg)
 		defer C.free(unsafe.Pointer(Cextramsg))
-		C.luaL_argerror(L.s, C.int(narg), Cextramsg)
+		C.luaL_argerror(L.state(), C.int(narg), Cextramsg)
 	}
 }
 
@@ -40,35 +40,35 @@ func (L *State) Argcheck(cond bool, narg int, extramsg string) {
 func (L *State) ArgError(narg int, extramsg string) int {
 	Cextramsg := C.CString(extramsg)
 	defer C.free(unsafe.Pointer(Cextramsg))
-	return int(C.luaL_argerror(L.s, C.int(narg), Cextramsg))
+	return int(C.luaL_argerror(L.state(), C.int(narg), Cextramsg))
 }
 
 // luaL_callmeta
 func (L *State) CallMeta(obj int, e string) int {
 	Ce := C.CString(e)
 	defer C.free(unsafe.Pointer(Ce))
-	return int(C.luaL_callmeta(L.s, C.int(obj), Ce))
+	return int(C.luaL_callmeta(L.state(), C.int(obj), Ce))
 }
 
 // luaL_checkany
 func (L *State) CheckAny(narg int) {
-	C.luaL_checkany(L.s, C.int(narg))
+	C.luaL_checkany(L.state(), C.int(narg))
 }
 
 // luaL_checkinteger
-func (L *State) CheckInteger(narg int) int {
-	return int(C.luaL_checkinteger(L.s, C.int(narg)))
+func (L *State) CheckInteger(narg int) int64 {
+	return int64(C.luaL_checkinteger(L.state(), C.int(narg)))
 }
 
 // luaL_checknumber
 func (L *State) CheckNumber(narg int) float64 {
-	return float64(C.luaL_checknumber(L.s, C.int(narg)))
+	return float64(C.luaL_checknumber(L.state(), C.int(narg)))
 }
 
 // luaL_checkstring
 func (L *State) CheckString(narg int) string {
 	var length C.size_t
-	return C.GoString(C.luaL_checklstring(L.s, C.int(narg), &length))
+	return C.GoString(C.luaL_checklstring(L.state(), C.int(narg), &length))
 }
 
 // luaL_checkoption
@@ -81,18 +81,21 @@ func (L *State) CheckOption(narg int, def string, lst []string) int {
 
 // luaL_checktype
 func (L *State) CheckType(narg int, t LuaValType) {
-	C.luaL_checktype(L.s, C.int(narg), C.int(t))
+	C.luaL_checktype(L.state(), C.int(narg), C.int(t))
 }
 
 // luaL_checkudata
 func (L *State) CheckUdata(narg int, tname string) unsafe.Pointer {
 	Ctname := C.CString(tname)
 	defer C.free(unsafe.Pointer(Ctname))
-	return unsafe.Pointer(C.luaL_checkudata(L.s, C.int(narg), Ctname))
+	return unsafe.Pointer(C.luaL_checkudata(L.state(), C.int(narg), Ctname))
 }
 
 // Executes file, returns nil for no errors or the lua error string on failure
 func (L *State) DoFile(filename string) error {
+	if L.isClosed() {
+		return ErrStateClosed
+	}
 	if r := L.LoadFile(filename); r != 0 {
 		return &LuaError{r, L.ToString(-1), L.StackTrace()}
 	}
@@ -101,6 +104,9 @@ func (L *State) DoFile(filename string) error {
 
 // Executes the string, returns nil for no errors or the lua error string on failure
 func (L *State) DoString(str string) error {
+	if L.isClosed() {
+		return ErrStateClosed
+	}
 	if r := L.LoadString(str); r != 0 {
 		return &LuaError{r, L.ToString(-1), L.StackTrace()}
 	}
@@ -118,14 +124,14 @@ func (L *State) MustDoString(str string) {
 func (L *State) GetMetaField(obj int, e string) bool {
 	Ce := C.CString(e)
 	defer C.free(unsafe.Pointer(Ce))
-	return C.luaL_getmetafield(L.s, C.int(obj), Ce) != 0
+	return C.luaL_getmetafield(L.state(), C.int(obj), Ce) != 0
 }
 
 // luaL_getmetatable
 func (L *State) LGetMetaTable(tname string) {
 	Ctname := C.CString(tname)
 	defer C.free(unsafe.Pointer(Ctname))
-	C.lua_getfield(L.s, LUA_REGISTRYINDEX, Ctname)
+	C.lua_getfield(L.state(), LUA_REGISTRYINDEX, Ctname)
 }
 
 // luaL_gsub
@@ -139,26 +145,42 @@ func (L *State) GSub(s string, p string, r string) string {
 		C.free(unsafe.Pointer(Cr))
 	}()
 
-	return C.GoString(C.luaL_gsub(L.s, Cs, Cp, Cr))
+	return C.GoString(C.luaL_gsub(L.state(), Cs, Cp, Cr))
 }
 
-// luaL_loadfile
+// luaL_loadfile, reads from the file system set with SetFS if any
 func (L *State) LoadFile(filename string) int {
+	if L.fsys != nil {
+		return L.loadFS(filename, "bt")
+	}
 	Cfilename := C.CString(filename)
 	defer C.free(unsafe.Pointer(Cfilename))
-	return int(C.luaL_loadfilex(L.s, Cfilename, nil))
+	return int(C.luaL_loadfilex(L.state(), Cfilename, nil))
+}
+
+// luaL_loadbufferx
+func (L *State) LoadBuffer(buf []byte, name string, mode string) int {
+	Cname := C.CString(name)
+	defer C.free(unsafe.Pointer(Cname))
+	Cmode := C.CString(mode)
+	defer C.free(unsafe.Pointer(Cmode))
+	var Cbuf *C.char
+	if len(buf) > 0 {
+		Cbuf = (*C.char)(unsafe.Pointer(&buf[0]))
+	}
+	return int(C.luaL_loadbufferx(L.state(), Cbuf, C.size_t(len(buf)), Cname, Cmode))
 }
 
 // luaL_loadstring
 func (L *State) LoadString(s string) int {
 	Cs := C.CString(s)
 	defer C.free(unsafe.Pointer(Cs))
-	return int(C.luaL_loadstring(L.s, Cs))
+	return int(C.luaL_loadstring(L.state(), Cs))
 }
 
 // lua_dump
 func (L *State) Dump() int {
-	ret := int(C.dump_chunk(L.s))
+	ret := int(C.dump_chunk(L.state()))
 	return ret
 }
 
@@ -168,7 +190,7 @@ func (L *State) Load(bs []byte, name string) int {
 	ckname := C.CString(name)
 	defer C.free(unsafe.Pointer(chunk))
 	defer C.free(unsafe.Pointer(ckname))
-	ret := int(C.load_chunk(L.s, chunk, C.int(len(bs)), ckname))
+	ret := int(C.load_chunk(L.state(), chunk, C.int(len(bs)), ckname))
 	if ret != 0 {
 		return ret
 	}
@@ -179,7 +201,7 @@ func (L *State) Load(bs []byte, name string) int {
 func (L *State) NewMetaTable(tname string) bool {
 	Ctname := C.CString(tname)
 	defer C.free(unsafe.Pointer(Ctname))
-	return C.luaL_newmetatable(L.s, Ctname) != 0
+	return C.luaL_newmetatable(L.state(), Ctname) != 0
 }
 
 // luaL_newstate
@@ -194,18 +216,18 @@ func NewState() *State {
 
 // luaL_openlibs
 func (L *State) OpenLibs() {
-	C.luaL_openlibs(L.s)
-	C.clua_hide_pcall(L.s)
+	C.luaL_openlibs(L.state())
+	C.clua_hide_pcall(L.state())
 }
 
 // luaL_optinteger
-func (L *State) OptInteger(narg int, d int) int {
-	return int(C.luaL_optinteger(L.s, C.int(narg), C.lua_Integer(d)))
+func (L *State) OptInteger(narg int, d int64) int64 {
+	return int64(C.luaL_optinteger(L.state(), C.int(narg), C.lua_Integer(d)))
 }
 
 // luaL_optnumber
 func (L *State) OptNumber(narg int, d float64) float64 {
-	return float64(C.luaL_optnumber(L.s, C.int(narg), C.lua_Number(d)))
+	return float64(C.luaL_optnumber(L.state(), C.int(narg), C.lua_Number(d)))
 }
 
 // luaL_optstring
@@ -213,25 +235,25 @@ func (L *State) OptString(narg int, d string) string {
 	var length C.size_t
 	Cd := C.CString(d)
 	defer C.free(unsafe.Pointer(Cd))
-	return C.GoString(C.luaL_optlstring(L.s, C.int(narg), Cd, &length))
+	return C.GoString(C.luaL_optlstring(L.state(), C.int(narg), Cd, &length))
 }
 
 // luaL_ref
 func (L *State) Ref(t int) int {
-	return int(C.luaL_ref(L.s, C.int(t)))
+	return int(C.luaL_ref(L.state(), C.int(t)))
 }
 
 // luaL_typename
 func (L *State) LTypename(index int) string {
-	return C.GoString(C.lua_typename(L.s, C.lua_type(L.s, C.int(index))))
+	return C.GoString(C.lua_typename(L.state(), C.lua_type(L.state(), C.int(index))))
 }
 
 // luaL_unref
 func (L *State) Unref(t int, ref int) {
-	C.luaL_unref(L.s, C.int(t), C.int(ref))
+	C.luaL_unref(L.state(), C.int(t), C.int(ref))
 }
 
 // luaL_where
 func (L *State) Where(lvl int) {
-	C.luaL_where(L.s, C.int(lvl))
+	C.luaL_where(L.state(), C.int(lvl))
 }
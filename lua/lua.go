@@ -6,12 +6,14 @@
 package lua
 
 /*
-#cgo CFLAGS: -I ${SRCDIR}/lua
+#cgo !lua54,!luajit CFLAGS: -I ${SRCDIR}/lua
 #cgo llua LDFLAGS: -llua
 #cgo luaa LDFLAGS: -llua -lm -ldl
-#cgo linux,!llua,!luaa LDFLAGS: -llua5.3
-#cgo darwin,!luaa LDFLAGS: -llua
-#cgo freebsd,!luaa LDFLAGS: -llua
+#cgo linux,!llua,!luaa,!lua54,!luajit LDFLAGS: -llua5.3
+#cgo linux,lua54,!llua,!luaa pkg-config: lua5.4
+#cgo luajit pkg-config: luajit
+#cgo darwin,!luaa,!luajit LDFLAGS: -llua
+#cgo freebsd,!luaa,!luajit LDFLAGS: -llua
 
 #include <lua.h>
 #include <stdlib.h>
@@ -20,7 +22,10 @@ package lua
 
 */
 import "C"
-import "unsafe"
+import (
+	"runtime"
+	"unsafe"
+)
 
 import "fmt"
 
@@ -32,10 +37,13 @@ type LuaStackEntry struct {
 }
 
 func newState(L *C.lua_State) *State {
-	newstate := &State{L, 0, make([]interface{}, 0, 8), make([]uint, 0, 8)}
+	newstate := &State{&stateData{s: L, registry: make([]interface{}, 0, 8), freeIndices: make([]uint, 0, 8)}}
 	registerGoState(newstate)
 	C.clua_setgostate(L, C.size_t(newstate.Index))
 	C.clua_initstate(L)
+	// threads inherit the hook of the State when they are created
+	newstate.resetHook()
+	runtime.SetFinalizer(newstate, finalizeState)
 	return newstate
 }
 
@@ -88,6 +96,9 @@ func (L *State) register(f interface{}) uint {
 	}
 	//fmt.Printf("\tregistering %d %v\n", index, f)
 	L.registry[index] = f
+	if L.pushSites != nil {
+		L.recordPushSite(index)
+	}
 	return index
 }
 
@@ -95,22 +106,56 @@ func (L *State) unregister(fid uint) {
 	//fmt.Printf("Unregistering %d (len: %d, value: %v)\n", fid, len(L.registry), L.registry[fid])
 	if (fid < uint(len(L.registry))) && (L.registry[fid] != nil) {
 		L.registry[fid] = nil
+		if fid < uint(len(L.pushSites)) {
+			L.pushSites[fid] = ""
+		}
 		L.addFreeIndex(fid)
 	}
 }
 
-// Like lua_pushcfunction pushes onto the stack a go function as user data
+// Like lua_pushcfunction pushes onto the stack a go function.
+//
+// The go function is wrapped in a Closure so that it reflects lua type 'function' when checking with type()
 func (L *State) PushGoFunction(f LuaGoFunction) {
+	L.PushGoClosureN(f, 0)
+}
+
+// Pushes onto the stack a go function as user data that can be called through its __call metamethod.
+//
+// Unlike values pushed with PushGoFunction the resulting value has lua type 'userdata'
+func (L *State) PushGoFunctionUserdata(f LuaGoFunction) {
 	fid := L.register(f)
-	C.clua_pushgofunction(L.s, C.uint(fid))
+	C.clua_pushgofunction(L.state(), C.uint(fid))
 }
 
 // PushGoClosure pushes a lua.LuaGoFunction to the stack wrapped in a Closure.
-// this permits the go function to reflect lua type 'function' when checking with type()
-// this implements behaviour akin to lua_pushcfunction() in lua C API.
+//
+// It is equivalent to PushGoFunction and is kept for compatibility.
 func (L *State) PushGoClosure(f LuaGoFunction) {
-	L.PushGoFunction(f)      // leaves Go function userdata on stack
-	C.clua_pushcallback(L.s) // wraps the userdata object with a closure making it into a function
+	L.PushGoClosureN(f, 0)
+}
+
+// Maximum number of upvalues of a Go closure, lua allows 255 upvalues and the first one holds the Go function
+const maxGoClosureUpvalues = 254
+
+// PushGoClosureN pushes a lua.LuaGoFunction wrapped in a Closure that captures the n values on top of the stack as upvalues, popping them.
+// This implements behaviour akin to lua_pushcclosure() in lua C API, the upvalues can be accessed from f using UpvalueIndex.
+//
+// Panics if n is negative, larger than maxGoClosureUpvalues or larger than the number of values on the stack.
+func (L *State) PushGoClosureN(f LuaGoFunction, n int) {
+	if n < 0 || n > maxGoClosureUpvalues || n > L.GetTop() {
+		panic(fmt.Sprintf("lua: PushGoClosureN: invalid number of upvalues %d (must be between 0 and %d, with %d values on the stack)", n, maxGoClosureUpvalues, L.GetTop()))
+	}
+	fid := L.register(f)
+	C.clua_pushgoclosure(L.state(), C.uint(fid), C.int(n))
+}
+
+// Returns the pseudo-index of the i-th upvalue of the running Go closure, like lua_upvalueindex.
+//
+// Upvalues are numbered from 1 in the order they were pushed before the call to PushGoClosureN.
+func (L *State) UpvalueIndex(i int) int {
+	// the first upvalue of every Go closure is the Go function itself
+	return C.GOLUA_UPVALUEBASE - (i + 1)
 }
 
 // Sets a metamethod to execute a go function
@@ -125,20 +170,18 @@ func (L *State) PushGoClosure(f LuaGoFunction) {
 // 	L.LGetMetaTable(tableName)
 // 	L.PushGoFunction(function)
 // 	L.SetField(-2, methodName)
-//
-// except this wouldn't work because pushing a go function results in user data not a cfunction
 func (L *State) SetMetaMethod(methodName string, f LuaGoFunction) {
-	L.PushGoFunction(f)      // leaves Go function userdata on stack
-	C.clua_pushcallback(L.s) // wraps the userdata object with a closure making it into a function
+	L.PushGoFunction(f)
 	L.SetField(-2, methodName)
 }
 
 // Pushes a Go struct onto the stack as user data.
 //
-// The user data will be rigged so that lua code can access and change to public members of simple types directly
+// The user data will be rigged so that lua code can access and change to public members of simple types directly.
+// Reading an unsigned field holding a value larger than math.MaxInt64, the largest lua integer, raises an error.
 func (L *State) PushGoStruct(iface interface{}) {
 	iid := L.register(iface)
-	C.clua_pushgostruct(L.s, C.uint(iid))
+	C.clua_pushgostruct(L.state(), C.uint(iid))
 }
 
 // Push a pointer onto the stack as user data.
@@ -146,12 +189,12 @@ func (L *State) PushGoStruct(iface interface{}) {
 // This function doesn't save a reference to the interface, it is the responsibility of the caller of this function to insure that the interface outlasts the lifetime of the lua object that this function creates.
 func (L *State) PushLightUserdata(ud *interface{}) {
 	//push
-	C.lua_pushlightuserdata(L.s, unsafe.Pointer(ud))
+	C.lua_pushlightuserdata(L.state(), unsafe.Pointer(ud))
 }
 
 // Creates a new user data object of specified size and returns it
 func (L *State) NewUserdata(size uintptr) unsafe.Pointer {
-	return unsafe.Pointer(C.lua_newuserdata(L.s, C.size_t(size)))
+	return C.clua_newuserdata(L.state(), C.size_t(size))
 }
 
 // Sets the AtPanic function, returns the old one
@@ -162,7 +205,7 @@ func (L *State) AtPanic(panicf LuaGoFunction) (oldpanicf LuaGoFunction) {
 	if panicf != nil {
 		fid = L.register(panicf)
 	}
-	oldres := interface{}(C.clua_atpanic(L.s, C.uint(fid)))
+	oldres := interface{}(C.clua_atpanic(L.state(), C.uint(fid)))
 	switch i := oldres.(type) {
 	case C.uint:
 		f := L.registry[uint(i)].(LuaGoFunction)
@@ -171,7 +214,7 @@ func (L *State) AtPanic(panicf LuaGoFunction) (oldpanicf LuaGoFunction) {
 		return f
 	case C.lua_CFunction:
 		return func(L1 *State) int {
-			return int(C.clua_callluacfunc(L1.s, i))
+			return int(C.clua_callluacfunc(L1.state(), i))
 		}
 	}
 	//generally we only get here if the panicf got set to something like nil
@@ -180,10 +223,21 @@ func (L *State) AtPanic(panicf LuaGoFunction) (oldpanicf LuaGoFunction) {
 }
 
 func (L *State) pcall(nargs, nresults, errfunc int) int {
-	return int(C.lua_pcallk(L.s, C.int(nargs), C.int(nresults), C.int(errfunc), 0, nil))
+	return int(C.lua_pcallk(L.state(), C.int(nargs), C.int(nresults), C.int(errfunc), 0, nil))
 }
 
 func (L *State) callEx(nargs, nresults int, catch bool) (err error) {
+	if L.isClosed() {
+		if catch {
+			return ErrStateClosed
+		}
+		panic(ErrStateClosed)
+	}
+	L.enterCall()
+	defer func() {
+		L.leaveCall(err)
+	}()
+
 	if catch {
 		defer func() {
 			if err2 := recover(); err2 != nil {
@@ -202,7 +256,7 @@ func (L *State) callEx(nargs, nresults int, catch bool) (err error) {
 	r := L.pcall(nargs, nresults, erridx)
 	L.Remove(erridx)
 	if r != 0 {
-		err = &LuaError{r, L.ToString(-1), L.StackTrace()}
+		err = L.callError(r)
 		if !catch {
 			panic(err)
 		}
@@ -210,6 +264,11 @@ func (L *State) callEx(nargs, nresults int, catch bool) (err error) {
 	return
 }
 
+// lua_absindex
+func (L *State) AbsIndex(index int) int {
+	return int(C.lua_absindex(L.state(), C.int(index)))
+}
+
 // lua_call
 func (L *State) Call(nargs, nresults int) (err error) {
 	return L.callEx(nargs, nresults, true)
@@ -222,117 +281,139 @@ func (L *State) MustCall(nargs, nresults int) {
 
 // lua_checkstack
 func (L *State) CheckStack(extra int) bool {
-	return C.lua_checkstack(L.s, C.int(extra)) != 0
+	return C.lua_checkstack(L.state(), C.int(extra)) != 0
 }
 
 // lua_close
+// Closing a State releases its lua heap and the go values it references, any use of the State afterwards panics with ErrStateClosed
+// (Call, DoString and DoFile return it instead). Close can be called several times, closing a thread closes its main State.
+//
+// States that are garbage collected without being closed are closed by a finalizer, which logs a warning
+// (a State referenced by one of the Go values it holds, like a closure pushed with PushGoFunction, is never collected).
 func (L *State) Close() {
-	C.lua_close(L.s)
+	if L.main != nil {
+		L.main.Close()
+		return
+	}
+	if L.s == nil {
+		return
+	}
+	runtime.SetFinalizer(L, nil)
+	L.closeMu.Lock()
+	C.clua_close(L.s)
+	L.s = nil
+	L.closeMu.Unlock()
 	unregisterGoState(L)
+	L.registry = nil
+	L.freeIndices = nil
+	L.pushSites = nil
+	L.fsys = nil
+	L.stdout = nil
 }
 
 // lua_concat
 func (L *State) Concat(n int) {
-	C.lua_concat(L.s, C.int(n))
+	C.lua_concat(L.state(), C.int(n))
 }
 
 // lua_createtable
 func (L *State) CreateTable(narr int, nrec int) {
-	C.lua_createtable(L.s, C.int(narr), C.int(nrec))
+	C.lua_createtable(L.state(), C.int(narr), C.int(nrec))
 }
 
 // lua_equal
 func (L *State) Equal(index1, index2 int) bool {
-	return C.lua_compare(L.s, C.int(index1), C.int(index2), C.LUA_OPEQ) == 1
+	return C.lua_compare(L.state(), C.int(index1), C.int(index2), C.LUA_OPEQ) == 1
 }
 
 // lua_gc
-func (L *State) GC(what, data int) int { return int(C.lua_gc(L.s, C.int(what), C.int(data))) }
+func (L *State) GC(what, data int) int { return int(C.clua_gc(L.state(), C.int(what), C.int(data))) }
 
 // lua_getfield
 func (L *State) GetField(index int, k string) {
-	Ck := C.CString(k)
-	defer C.free(unsafe.Pointer(Ck))
-	C.lua_getfield(L.s, C.int(index), Ck)
+	Ck, Clen := goStringData(k)
+	C.clua_getfieldn(L.state(), C.int(index), Ck, Clen)
 }
 
 // Pushes on the stack the value of a global variable (lua_getglobal)
 func (L *State) GetGlobal(name string) {
-	Ck := C.CString(name)
-	defer C.free(unsafe.Pointer(Ck))
-	C.lua_getglobal(L.s, Ck)
+	Cname, Clen := goStringData(name)
+	C.clua_getglobaln(L.state(), Cname, Clen)
 }
 
 // lua_getmetatable
 func (L *State) GetMetaTable(index int) bool {
-	return C.lua_getmetatable(L.s, C.int(index)) != 0
+	return C.lua_getmetatable(L.state(), C.int(index)) != 0
 }
 
 // lua_gettable
-func (L *State) GetTable(index int) { C.lua_gettable(L.s, C.int(index)) }
+func (L *State) GetTable(index int) { C.lua_gettable(L.state(), C.int(index)) }
 
 // lua_gettop
-func (L *State) GetTop() int { return int(C.lua_gettop(L.s)) }
+func (L *State) GetTop() int { return int(C.lua_gettop(L.state())) }
 
 // lua_insert
-func (L *State) Insert(index int) { C.lua_rotate(L.s, C.int(index), 1) }
+func (L *State) Insert(index int) { C.lua_rotate(L.state(), C.int(index), 1) }
 
 // Returns true if lua_type == LUA_TBOOLEAN
 func (L *State) IsBoolean(index int) bool {
-	return LuaValType(C.lua_type(L.s, C.int(index))) == LUA_TBOOLEAN
+	return LuaValType(C.lua_type(L.state(), C.int(index))) == LUA_TBOOLEAN
 }
 
-// Returns true if the value at index is a LuaGoFunction
+// Returns true if the value at index is a LuaGoFunction, either pushed with PushGoFunction or PushGoFunctionUserdata
 func (L *State) IsGoFunction(index int) bool {
-	return C.clua_isgofunction(L.s, C.int(index)) != 0
+	return C.clua_isgofunction(L.state(), C.int(index)) != 0
 }
 
 // Returns true if the value at index is user data pushed with PushGoStruct
 func (L *State) IsGoStruct(index int) bool {
-	return C.clua_isgostruct(L.s, C.int(index)) != 0
+	return C.clua_isgostruct(L.state(), C.int(index)) != 0
 }
 
-// Returns true if the value at index is user data pushed with PushGoFunction
+// Returns true if the value at index is a function (lua_isfunction)
 func (L *State) IsFunction(index int) bool {
-	return LuaValType(C.lua_type(L.s, C.int(index))) == LUA_TFUNCTION
+	return LuaValType(C.lua_type(L.state(), C.int(index))) == LUA_TFUNCTION
 }
 
 // Returns true if the value at index is light user data
 func (L *State) IsLightUserdata(index int) bool {
-	return LuaValType(C.lua_type(L.s, C.int(index))) == LUA_TLIGHTUSERDATA
+	return LuaValType(C.lua_type(L.state(), C.int(index))) == LUA_TLIGHTUSERDATA
 }
 
 // lua_isnil
-func (L *State) IsNil(index int) bool { return LuaValType(C.lua_type(L.s, C.int(index))) == LUA_TNIL }
+func (L *State) IsNil(index int) bool { return LuaValType(C.lua_type(L.state(), C.int(index))) == LUA_TNIL }
 
 // lua_isnone
-func (L *State) IsNone(index int) bool { return LuaValType(C.lua_type(L.s, C.int(index))) == LUA_TNONE }
+func (L *State) IsNone(index int) bool { return LuaValType(C.lua_type(L.state(), C.int(index))) == LUA_TNONE }
 
 // lua_isnoneornil
-func (L *State) IsNoneOrNil(index int) bool { return int(C.lua_type(L.s, C.int(index))) <= 0 }
+func (L *State) IsNoneOrNil(index int) bool { return int(C.lua_type(L.state(), C.int(index))) <= 0 }
 
 // lua_isnumber
-func (L *State) IsNumber(index int) bool { return C.lua_isnumber(L.s, C.int(index)) == 1 }
+func (L *State) IsNumber(index int) bool { return C.lua_isnumber(L.state(), C.int(index)) == 1 }
+
+// lua_isinteger, true if the value is a number with the integer subtype (floats and strings are never integers)
+func (L *State) IsInteger(index int) bool { return C.lua_isinteger(L.state(), C.int(index)) != 0 }
 
 // lua_isstring
-func (L *State) IsString(index int) bool { return C.lua_isstring(L.s, C.int(index)) == 1 }
+func (L *State) IsString(index int) bool { return C.lua_isstring(L.state(), C.int(index)) == 1 }
 
 // lua_istable
 func (L *State) IsTable(index int) bool {
-	return LuaValType(C.lua_type(L.s, C.int(index))) == LUA_TTABLE
+	return LuaValType(C.lua_type(L.state(), C.int(index))) == LUA_TTABLE
 }
 
 // lua_isthread
 func (L *State) IsThread(index int) bool {
-	return LuaValType(C.lua_type(L.s, C.int(index))) == LUA_TTHREAD
+	return LuaValType(C.lua_type(L.state(), C.int(index))) == LUA_TTHREAD
 }
 
 // lua_isuserdata
-func (L *State) IsUserdata(index int) bool { return C.lua_isuserdata(L.s, C.int(index)) == 1 }
+func (L *State) IsUserdata(index int) bool { return C.lua_isuserdata(L.state(), C.int(index)) == 1 }
 
 // lua_lessthan
 func (L *State) LessThan(index1, index2 int) bool {
-	return C.lua_compare(L.s, C.int(index1), C.int(index2), C.LUA_OPLT) == 1
+	return C.lua_compare(L.state(), C.int(index1), C.int(index2), C.LUA_OPLT) == 1
 }
 
 // Creates a new lua interpreter state with the given allocation function
@@ -341,9 +422,49 @@ func NewStateAlloc(f Alloc) *State {
 	return newState(ls)
 }
 
+// Memory usage statistics of a State created with NewStateLimit
+type MemStats struct {
+	// Maximum size of the lua heap in bytes, 0 if unlimited
+	Limit uint64
+	// Current size of the lua heap in bytes
+	Current uint64
+	// Largest size reached by the lua heap in bytes
+	Peak uint64
+	// Total number of allocations performed
+	Allocations uint64
+}
+
+// Creates a new lua interpreter state whose allocations are performed in C and can not make the lua heap grow past limit bytes.
+//
+// Allocations exceeding the limit fail and result in a LUA_ERRMEM error, a limit of 0 means no limit.
+// Returns nil if the limit is too small to hold a fresh state or the state could not be allocated.
+func NewStateLimit(limit uint64) *State {
+	ls := C.clua_newstatelimited(C.size_t(limit))
+	if ls == nil {
+		return nil
+	}
+	return newState(ls)
+}
+
+// Changes the memory limit of a State created with NewStateLimit, returns false if the State uses a different allocator.
+//
+// Lowering the limit below the current usage doesn't free memory, further allocations will fail until enough memory is collected.
+func (L *State) SetMemoryLimit(limit uint64) bool {
+	return C.clua_setmemlimit(L.state(), C.size_t(limit)) != 0
+}
+
+// Returns the memory usage statistics of a State created with NewStateLimit, ok is false if the State uses a different allocator.
+func (L *State) MemStats() (stats MemStats, ok bool) {
+	var limit, current, peak, allocations C.size_t
+	if C.clua_getmemstats(L.state(), &limit, &current, &peak, &allocations) == 0 {
+		return stats, false
+	}
+	return MemStats{uint64(limit), uint64(current), uint64(peak), uint64(allocations)}, true
+}
+
 // lua_newtable
 func (L *State) NewTable() {
-	C.lua_createtable(L.s, 0, 0)
+	C.lua_createtable(L.state(), 0, 0)
 }
 
 // lua_newthread
@@ -351,25 +472,29 @@ func (L *State) NewThread() *State {
 	//TODO: call newState with result from C.lua_newthread and return it
 	//TODO: should have same lists as parent
 	//		but may complicate gc
-	s := C.lua_newthread(L.s)
-	return &State{s, 0, nil, nil}
+	s := C.lua_newthread(L.state())
+	main := L
+	if L.main != nil {
+		main = L.main
+	}
+	return &State{&stateData{s: s, main: main}}
 }
 
 // lua_next
 func (L *State) Next(index int) int {
-	return int(C.lua_next(L.s, C.int(index)))
+	return int(C.lua_next(L.state(), C.int(index)))
 }
 
 // lua_objlen
 func (L *State) ObjLen(index int) uint {
-	return uint(C.lua_rawlen(L.s, C.int(index)))
+	return uint(C.lua_rawlen(L.state(), C.int(index)))
 }
 
 // lua_pop
 func (L *State) Pop(n int) {
 	//Why is this implemented this way? I don't get it...
-	//C.lua_pop(L.s, C.int(n));
-	C.lua_settop(L.s, C.int(-n-1))
+	//C.lua_pop(L.state(), C.int(n));
+	C.lua_settop(L.state(), C.int(-n-1))
 }
 
 // lua_pushboolean
@@ -380,68 +505,78 @@ func (L *State) PushBoolean(b bool) {
 	} else {
 		bint = 0
 	}
-	C.lua_pushboolean(L.s, C.int(bint))
+	C.lua_pushboolean(L.state(), C.int(bint))
 }
 
 // lua_pushstring
 func (L *State) PushString(str string) {
-	Cstr := C.CString(str)
-	defer C.free(unsafe.Pointer(Cstr))
-	C.lua_pushlstring(L.s, Cstr, C.size_t(len(str)))
+	Cstr, Clen := goStringData(str)
+	C.lua_pushlstring(L.state(), Cstr, Clen)
 }
 
+// Pushes the contents of b as a lua string, an empty or nil slice pushes an empty string
 func (L *State) PushBytes(b []byte) {
-	C.lua_pushlstring(L.s, (*C.char)(unsafe.Pointer(&b[0])), C.size_t(len(b)))
+	if len(b) == 0 {
+		C.lua_pushlstring(L.state(), nil, 0)
+		return
+	}
+	C.lua_pushlstring(L.state(), (*C.char)(unsafe.Pointer(&b[0])), C.size_t(len(b)))
+}
+
+// Returns the bytes of str for the C functions that copy them before returning (lua_pushlstring, ...), avoiding a C.CString copy.
+// The data of go strings doesn't contain go pointers so it can be passed to C.
+func goStringData(str string) (*C.char, C.size_t) {
+	return (*C.char)(unsafe.Pointer(unsafe.StringData(str))), C.size_t(len(str))
 }
 
 // lua_pushinteger
 func (L *State) PushInteger(n int64) {
-	C.lua_pushinteger(L.s, C.lua_Integer(n))
+	C.lua_pushinteger(L.state(), C.lua_Integer(n))
 }
 
 // lua_pushnil
 func (L *State) PushNil() {
-	C.lua_pushnil(L.s)
+	C.lua_pushnil(L.state())
 }
 
 // lua_pushnumber
 func (L *State) PushNumber(n float64) {
-	C.lua_pushnumber(L.s, C.lua_Number(n))
+	C.lua_pushnumber(L.state(), C.lua_Number(n))
 }
 
 // lua_pushthread
 func (L *State) PushThread() (isMain bool) {
-	return C.lua_pushthread(L.s) != 0
+	return C.lua_pushthread(L.state()) != 0
 }
 
 // lua_pushvalue
 func (L *State) PushValue(index int) {
-	C.lua_pushvalue(L.s, C.int(index))
+	C.lua_pushvalue(L.state(), C.int(index))
 }
 
 // lua_rawequal
 func (L *State) RawEqual(index1 int, index2 int) bool {
-	return C.lua_rawequal(L.s, C.int(index1), C.int(index2)) != 0
+	return C.lua_rawequal(L.state(), C.int(index1), C.int(index2)) != 0
 }
 
 // lua_rawget
 func (L *State) RawGet(index int) {
-	C.lua_rawget(L.s, C.int(index))
+	C.lua_rawget(L.state(), C.int(index))
 }
 
 // lua_rawgeti
-func (L *State) RawGeti(index int, n int) {
-	C.lua_rawgeti(L.s, C.int(index), C.lua_Integer(n))
+func (L *State) RawGeti(index int, n int64) {
+	C.clua_rawgeti(L.state(), C.int(index), C.lua_Integer(n))
 }
 
 // lua_rawset
 func (L *State) RawSet(index int) {
-	C.lua_rawset(L.s, C.int(index))
+	C.lua_rawset(L.state(), C.int(index))
 }
 
 // lua_rawseti
-func (L *State) RawSeti(index int, n int) {
-	C.lua_rawseti(L.s, C.int(index), C.lua_Integer(n))
+func (L *State) RawSeti(index int, n int64) {
+	C.clua_rawseti(L.state(), C.int(index), C.lua_Integer(n))
 }
 
 // Registers a Go function as a global variable
@@ -452,65 +587,71 @@ func (L *State) Register(name string, f LuaGoFunction) {
 
 // lua_remove
 func (L *State) Remove(index int) {
-	C.lua_rotate(L.s, C.int(index), -1)
+	C.lua_rotate(L.state(), C.int(index), -1)
 	//C.lua_pop(L, 1)
-	C.lua_settop(L.s, C.int(-2))
+	C.lua_settop(L.state(), C.int(-2))
 }
 
 // lua_replace
 func (L *State) Replace(index int) {
-	C.lua_copy(L.s, -1, C.int(index))
-	//C.lua_pop(L.s, 1)
-	C.lua_settop(L.s, -2)
+	C.lua_copy(L.state(), -1, C.int(index))
+	//C.lua_pop(L.state(), 1)
+	C.lua_settop(L.state(), -2)
 }
 
 // lua_resume
 func (L *State) Resume(narg int) int {
-	return int(C.lua_resume(L.s, nil, C.int(narg)))
+	status, _ := L.ResumeN(narg)
+	return status
+}
+
+// Like Resume but also returns the number of values yielded or returned by the coroutine, that are on the top of its stack (the nres parameter of lua_resume in lua 5.4)
+func (L *State) ResumeN(narg int) (status int, nres int) {
+	var Cnres C.int
+	status = int(C.clua_resume(L.state(), nil, C.int(narg), &Cnres))
+	return status, int(Cnres)
 }
 
 // lua_setallocf
 func (L *State) SetAllocf(f Alloc) {
-	C.clua_setallocf(L.s, unsafe.Pointer(&f))
+	C.clua_setallocf(L.state(), unsafe.Pointer(&f))
 }
 
 // lua_setfield
 func (L *State) SetField(index int, k string) {
-	Ck := C.CString(k)
-	defer C.free(unsafe.Pointer(Ck))
-	C.lua_setfield(L.s, C.int(index), Ck)
+	Ck, Clen := goStringData(k)
+	C.clua_setfieldn(L.state(), C.int(index), Ck, Clen)
 }
 
 // lua_setglobal
 func (L *State) SetGlobal(name string) {
-	Cname := C.CString(name)
-	defer C.free(unsafe.Pointer(Cname))
-	C.lua_setglobal(L.s, Cname)
+	Cname, Clen := goStringData(name)
+	C.clua_setglobaln(L.state(), Cname, Clen)
 }
 
 // lua_setmetatable
 func (L *State) SetMetaTable(index int) {
-	C.lua_setmetatable(L.s, C.int(index))
+	C.lua_setmetatable(L.state(), C.int(index))
 }
 
 // lua_settable
 func (L *State) SetTable(index int) {
-	C.lua_settable(L.s, C.int(index))
+	C.lua_settable(L.state(), C.int(index))
 }
 
 // lua_settop
 func (L *State) SetTop(index int) {
-	C.lua_settop(L.s, C.int(index))
+	C.lua_settop(L.state(), C.int(index))
 }
 
 // lua_status
 func (L *State) Status() int {
-	return int(C.lua_status(L.s))
+	return int(C.lua_status(L.state()))
 }
 
 // lua_toboolean
 func (L *State) ToBoolean(index int) bool {
-	return C.lua_toboolean(L.s, C.int(index)) != 0
+	return C.lua_toboolean(L.state(), C.int(index)) != 0
 }
 
 // Returns the value at index as a Go function (it must be something pushed with PushGoFunction)
@@ -518,7 +659,7 @@ func (L *State) ToGoFunction(index int) (f LuaGoFunction) {
 	if !L.IsGoFunction(index) {
 		return nil
 	}
-	fid := C.clua_togofunction(L.s, C.int(index))
+	fid := C.clua_togofunction(L.state(), C.int(index))
 	if fid < 0 {
 		return nil
 	}
@@ -530,7 +671,7 @@ func (L *State) ToGoStruct(index int) (f interface{}) {
 	if !L.IsGoStruct(index) {
 		return nil
 	}
-	fid := C.clua_togostruct(L.s, C.int(index))
+	fid := C.clua_togostruct(L.state(), C.int(index))
 	if fid < 0 {
 		return nil
 	}
@@ -540,117 +681,134 @@ func (L *State) ToGoStruct(index int) (f interface{}) {
 // lua_tostring
 func (L *State) ToString(index int) string {
 	var size C.size_t
-	r := C.lua_tolstring(L.s, C.int(index), &size)
+	r := C.lua_tolstring(L.state(), C.int(index), &size)
 	return C.GoStringN(r, C.int(size))
 }
 
 func (L *State) ToBytes(index int) []byte {
 	var size C.size_t
-	b := C.lua_tolstring(L.s, C.int(index), &size)
+	b := C.lua_tolstring(L.state(), C.int(index), &size)
 	return C.GoBytes(unsafe.Pointer(b), C.int(size))
 }
 
-// lua_tointeger
-func (L *State) ToInteger(index int) int {
-	return int(C.lua_tointegerx(L.s, C.int(index), nil))
+// lua_tointeger, returns 0 if the value isn't an integer or a float or string convertible to one
+func (L *State) ToInteger(index int) int64 {
+	return int64(C.lua_tointegerx(L.state(), C.int(index), nil))
+}
+
+// lua_tointegerx, ok is false if the value isn't an integer or a float or string with an exact integer value
+func (L *State) ToIntegerX(index int) (n int64, ok bool) {
+	var isnum C.int
+	n = int64(C.clua_tointegerx(L.state(), C.int(index), &isnum))
+	return n, isnum != 0
 }
 
 // lua_tonumber
 func (L *State) ToNumber(index int) float64 {
-	return float64(C.lua_tonumberx(L.s, C.int(index), nil))
+	return float64(C.lua_tonumberx(L.state(), C.int(index), nil))
+}
+
+// lua_tonumberx, ok is false if the value isn't a number or a string convertible to one
+func (L *State) ToNumberX(index int) (n float64, ok bool) {
+	var isnum C.int
+	n = float64(C.lua_tonumberx(L.state(), C.int(index), &isnum))
+	return n, isnum != 0
 }
 
 // lua_topointer
 func (L *State) ToPointer(index int) uintptr {
-	return uintptr(C.lua_topointer(L.s, C.int(index)))
+	return uintptr(C.lua_topointer(L.state(), C.int(index)))
 }
 
 // lua_tothread
 func (L *State) ToThread(index int) *State {
 	//TODO: find a way to link lua_State* to existing *State, return that
-	return &State{}
+	return &State{&stateData{}}
 }
 
 // lua_touserdata
 func (L *State) ToUserdata(index int) unsafe.Pointer {
-	return unsafe.Pointer(C.lua_touserdata(L.s, C.int(index)))
+	return unsafe.Pointer(C.lua_touserdata(L.state(), C.int(index)))
 }
 
 // lua_type
 func (L *State) Type(index int) LuaValType {
-	return LuaValType(C.lua_type(L.s, C.int(index)))
+	return LuaValType(C.lua_type(L.state(), C.int(index)))
 }
 
 // lua_typename
 func (L *State) Typename(tp int) string {
-	return C.GoString(C.lua_typename(L.s, C.int(tp)))
+	return C.GoString(C.lua_typename(L.state(), C.int(tp)))
 }
 
 // lua_xmove
 func XMove(from *State, to *State, n int) {
-	C.lua_xmove(from.s, to.s, C.int(n))
+	C.lua_xmove(from.state(), to.state(), C.int(n))
 }
 
 // lua_yield
 func (L *State) Yield(nresults int) int {
-	return int(C.lua_yieldk(L.s, C.int(nresults), 0, nil))
+	return int(C.lua_yieldk(L.state(), C.int(nresults), 0, nil))
 }
 
 // Restricted library opens
 
 // Calls luaopen_base
 func (L *State) OpenBase() {
-	C.clua_openbase(L.s)
+	C.clua_openbase(L.state())
 }
 
 // Calls luaopen_io
 func (L *State) OpenIO() {
-	C.clua_openio(L.s)
+	C.clua_openio(L.state())
 }
 
 // Calls luaopen_math
 func (L *State) OpenMath() {
-	C.clua_openmath(L.s)
+	C.clua_openmath(L.state())
 }
 
 // Calls luaopen_package
 func (L *State) OpenPackage() {
-	C.clua_openpackage(L.s)
+	C.clua_openpackage(L.state())
 }
 
 // Calls luaopen_string
 func (L *State) OpenString() {
-	C.clua_openstring(L.s)
+	C.clua_openstring(L.state())
 }
 
 // Calls luaopen_table
 func (L *State) OpenTable() {
-	C.clua_opentable(L.s)
+	C.clua_opentable(L.state())
 }
 
 // Calls luaopen_os
 func (L *State) OpenOS() {
-	C.clua_openos(L.s)
+	C.clua_openos(L.state())
 }
 
 // Calls luaopen_debug
 func (L *State) OpenDebug() {
-	C.clua_opendebug(L.s)
+	C.clua_opendebug(L.state())
 }
 
-// Calls luaopen_bit32
+// Calls luaopen_bit32, does nothing on lua 5.4 where the library doesn't exist
 func (L *State) OpenBit32() {
-	C.clua_openbit32(L.s)
+	C.clua_openbit32(L.state())
 }
 
 // Calls luaopen_coroutine
 func (L *State) OpenCoroutine() {
-	C.clua_opencoroutine(L.s)
+	C.clua_opencoroutine(L.state())
 }
 
 // Sets the maximum number of operations to execute at instrNumber, after this the execution ends
+//
+// Deprecated: the limit applies to every instrNumber instructions executed by the State and can not be queried, use SetInstructionBudget instead.
 func (L *State) SetExecutionLimit(instrNumber int) {
-	C.clua_setexecutionlimit(L.s, C.int(instrNumber))
+	L.executionLimit = instrNumber
+	C.clua_setexecutionlimit(L.state(), C.int(instrNumber))
 }
 
 // Returns the current stack trace
@@ -660,8 +818,8 @@ func (L *State) StackTrace() []LuaStackEntry {
 	Sln := C.CString("Sln")
 	defer C.free(unsafe.Pointer(Sln))
 
-	for depth := 0; C.lua_getstack(L.s, C.int(depth), &d) > 0; depth++ {
-		C.lua_getinfo(L.s, Sln, &d)
+	for depth := 0; C.lua_getstack(L.state(), C.int(depth), &d) > 0; depth++ {
+		C.lua_getinfo(L.state(), Sln, &d)
 		ssb := make([]byte, C.LUA_IDSIZE)
 		for i := 0; i < C.LUA_IDSIZE; i++ {
 			ssb[i] = byte(d.short_src[i])
@@ -692,5 +850,5 @@ func (L *State) NewError(msg string) *LuaError {
 }
 
 func (L *State) GetState() *C.lua_State {
-	return L.s
+	return L.state()
 }
@@ -0,0 +1,6 @@
+//go:build !lua54 && !luajit
+
+package lua
+
+// Index of the registry where luaL_ref keeps the list of released references
+const refFreelist = 0
@@ -0,0 +1,117 @@
+//go:build lua54
+
+package lua
+
+/*
+#include <lua.h>
+#include <lauxlib.h>
+#include <stdlib.h>
+#include "golua.h"
+
+// refFreelist and lua_closeslot need lua 5.4.3 or later
+#if !defined(LUA_VERSION_RELEASE_NUM) || LUA_VERSION_RELEASE_NUM < 50403
+#error "the lua54 build tag requires Lua 5.4.3 or later"
+#endif
+*/
+import "C"
+
+import (
+	"io"
+	"unsafe"
+)
+
+// Index of the registry where luaL_ref keeps the list of released references (lua 5.4.3 and later)
+const refFreelist = C.LUA_RIDX_LAST + 1
+
+const (
+	LUA_GCGEN = C.LUA_GCGEN
+	LUA_GCINC = C.LUA_GCINC
+)
+
+// lua_newuserdatauv, creates a user data object of specified size with nuvalue user values
+func (L *State) NewUserdataUV(size uintptr, nuvalue int) unsafe.Pointer {
+	return C.lua_newuserdatauv(L.state(), C.size_t(size), C.int(nuvalue))
+}
+
+// lua_getiuservalue, pushes the n-th user value of the userdata at index and returns its type (LUA_TNONE if it doesn't exist)
+func (L *State) GetIUserValue(index int, n int) LuaValType {
+	return LuaValType(C.lua_getiuservalue(L.state(), C.int(index), C.int(n)))
+}
+
+// lua_setiuservalue, pops a value and sets it as the n-th user value of the userdata at index, returns false if it doesn't have that user value
+func (L *State) SetIUserValue(index int, n int) bool {
+	return C.lua_setiuservalue(L.state(), C.int(index), C.int(n)) != 0
+}
+
+// lua_toclose, marks the value at index as a to-be-closed variable: its __close metamethod is called when it goes out of scope (see CloseSlot and SetTop)
+func (L *State) ToClose(index int) {
+	C.lua_toclose(L.state(), C.int(index))
+}
+
+// lua_closeslot, closes the to-be-closed slot at index and sets its value to nil
+func (L *State) CloseSlot(index int) {
+	C.lua_closeslot(L.state(), C.int(index))
+}
+
+// Pushes a value whose __close metamethod closes c, so that lua code can declare it as a to-be-closed variable:
+//
+//	local f <close> = open_go_resource()
+//
+// c is closed at most once, an error returned by Close is raised as a lua error.
+func (L *State) PushCloser(c io.Closer) {
+	closed := false
+	L.NewTable()
+	L.CreateTable(0, 2)
+	L.PushGoFunction(func(L *State) int {
+		if closed {
+			return 0
+		}
+		closed = true
+		if err := c.Close(); err != nil {
+			L.RaiseError(err.Error())
+		}
+		return 0
+	})
+	L.SetField(-2, "__close")
+	L.PushString("GoCloser")
+	L.SetField(-2, "__name")
+	L.SetMetaTable(-2)
+}
+
+// lua_resetthread, closes the pending to-be-closed variables of the thread and resets it, returns the status of the thread
+func (L *State) ResetThread() int {
+	return int(C.lua_resetthread(L.state()))
+}
+
+// Switches the garbage collector to generational mode with the given parameters (0 keeps the current value), returns true if it was in incremental mode
+func (L *State) SetGCGenerational(minormul, majormul int) (wasIncremental bool) {
+	return C.clua_gcgen(L.state(), C.int(minormul), C.int(majormul)) == C.LUA_GCINC
+}
+
+// Switches the garbage collector to incremental mode with the given parameters (0 keeps the current value), returns true if it was in generational mode
+func (L *State) SetGCIncremental(pause, stepmul, stepsize int) (wasGenerational bool) {
+	return C.clua_gcinc(L.state(), C.int(pause), C.int(stepmul), C.int(stepsize)) == C.LUA_GCGEN
+}
+
+// Installs handler as the warning function of L (lua_setwarnf), handler receives each complete warning emitted by warn or Warning.
+// Control messages ("@on", "@off", ...) are passed to handler as well. A nil handler disables warnings.
+func (L *State) SetWarnHandler(handler func(msg string)) {
+	L.warnHandler = handler
+	L.warnPieces = nil
+	enable := C.int(0)
+	if handler != nil {
+		enable = 1
+	}
+	C.clua_setwarnf(L.state(), enable)
+}
+
+// lua_warning, emits a warning, tocont is true if msg is continued by the next call
+func (L *State) Warning(msg string, tocont bool) {
+	Cmsg := C.CString(msg)
+	defer C.free(unsafe.Pointer(Cmsg))
+	Ctocont := C.int(0)
+	if tocont {
+		Ctocont = 1
+	}
+	C.lua_warning(L.state(), Cmsg, Ctocont)
+}
@@ -0,0 +1,15 @@
+//go:build lua54 && go1.21
+
+package lua
+
+import "log/slog"
+
+// Returns a warning handler logging warnings to logger at the warn level, control messages are ignored
+func SlogWarnHandler(logger *slog.Logger) func(msg string) {
+	return func(msg string) {
+		if len(msg) > 0 && msg[0] == '@' {
+			return
+		}
+		logger.Warn(msg, "source", "lua")
+	}
+}
@@ -0,0 +1,91 @@
+//go:build lua54
+
+package lua
+
+import (
+	"errors"
+	"testing"
+)
+
+type testCloser struct {
+	n   int
+	err error
+}
+
+func (c *testCloser) Close() error {
+	c.n++
+	return c.err
+}
+
+func TestToBeClosed(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	c := &testCloser{}
+	L.Register("resource", func(L *State) int {
+		L.PushCloser(c)
+		return 1
+	})
+	L.MustDoString("do local r <close> = resource() end")
+	if c.n != 1 {
+		t.Fatalf("Closer called %d times", c.n)
+	}
+
+	c.err = errors.New("close failed")
+	if err := L.DoString("do local r <close> = resource() end"); err == nil {
+		t.Fatal("Close error not raised")
+	}
+
+	L.PushCloser(c)
+	L.ToClose(-1)
+	L.CloseSlot(-1)
+	if c.n != 3 {
+		t.Fatalf("Closer called %d times", c.n)
+	}
+}
+
+func TestWarnHandler(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	var msgs []string
+	L.SetWarnHandler(func(msg string) { msgs = append(msgs, msg) })
+	L.MustDoString(`warn("hello ", "world")`)
+	L.Warning("from ", true)
+	L.Warning("go", false)
+	if len(msgs) != 2 || msgs[0] != "hello world" || msgs[1] != "from go" {
+		t.Fatalf("Unexpected warnings: %q", msgs)
+	}
+}
+
+func TestUserValues(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+
+	L.NewUserdataUV(8, 2)
+	L.PushString("second")
+	if !L.SetIUserValue(-2, 2) {
+		t.Fatal("User value not set")
+	}
+	L.PushString("third")
+	if L.SetIUserValue(-2, 3) {
+		t.Fatal("Nonexistent user value set")
+	}
+	if L.GetIUserValue(-1, 2) != LUA_TSTRING || L.ToString(-1) != "second" {
+		t.Fatal("Wrong user value")
+	}
+}
+
+func TestGCModes(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+
+	if !L.SetGCGenerational(0, 0) {
+		t.Fatal("Collector not incremental by default")
+	}
+	if !L.SetGCIncremental(0, 0, 0) {
+		t.Fatal("Collector not switched to generational mode")
+	}
+}
@@ -4,6 +4,7 @@ package lua
 #include <lua.h>
  #include <lauxlib.h>
  #include <lualib.h>
+ #include "golua_compat.h"
 
 */
 import "C"
@@ -30,6 +31,8 @@ const (
 	LUA_AUTHORS       = C.LUA_AUTHORS
 	LUA_MULTRET       = C.LUA_MULTRET
 	LUA_REGISTRYINDEX = C.LUA_REGISTRYINDEX
+	LUA_RIDX_GLOBALS  = C.LUA_RIDX_GLOBALS
+	LUA_OK            = C.LUA_OK
 	LUA_YIELD         = C.LUA_YIELD
 	LUA_ERRRUN        = C.LUA_ERRRUN
 	LUA_ERRSYNTAX     = C.LUA_ERRSYNTAX
@@ -45,6 +48,7 @@ const (
 	LUA_GCSTEP        = C.LUA_GCSTEP
 	LUA_GCSETPAUSE    = C.LUA_GCSETPAUSE
 	LUA_GCSETSTEPMUL  = C.LUA_GCSETSTEPMUL
+	LUA_GCISRUNNING   = C.LUA_GCISRUNNING
 	LUA_HOOKCALL      = C.LUA_HOOKCALL
 	LUA_HOOKRET       = C.LUA_HOOKRET
 	LUA_HOOKLINE      = C.LUA_HOOKLINE
@@ -1,6 +1,7 @@
 package lua
 
 import (
+	"math"
 	"testing"
 	"unsafe"
 )
@@ -165,8 +166,8 @@ func TestLikeBasic(t *testing.T) {
 		return 0
 	}
 
-	test2Arg := -1
-	test2Argfrombottom := -1
+	test2Arg := int64(-1)
+	test2Argfrombottom := int64(-1)
 	test2 := func(L *State) int {
 		test2Arg = L.CheckInteger(-1)
 		test2Argfrombottom = L.CheckInteger(1)
@@ -215,7 +216,7 @@ func TestLikeQuickstart(t *testing.T) {
 	adder := func(L *State) int {
 		a := L.ToInteger(1)
 		b := L.ToInteger(2)
-		L.PushInteger(int64(a + b))
+		L.PushInteger(a + b)
 		return 1
 	}
 
@@ -396,6 +397,79 @@ func TestConv(t *testing.T) {
 	}
 }
 
+func TestIntegerConv(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+
+	L.PushInteger(math.MaxInt64)
+	if !L.IsInteger(-1) || L.ToInteger(-1) != math.MaxInt64 {
+		t.Fatal("Integer not preserved")
+	}
+	L.PushNumber(2)
+	if L.IsInteger(-1) {
+		t.Fatal("Float reported as integer")
+	}
+	if n, ok := L.ToIntegerX(-1); !ok || n != 2 {
+		t.Fatalf("Float with an integer value not converted: %d %v", n, ok)
+	}
+	L.PushNumber(2.5)
+	if _, ok := L.ToIntegerX(-1); ok {
+		t.Fatal("Float without an integer value converted")
+	}
+	L.PushString("x")
+	if _, ok := L.ToNumberX(-1); ok {
+		t.Fatal("Non numeric string converted")
+	}
+	L.Pop(4)
+
+	L.NewTable()
+	L.PushString("big")
+	L.RawSeti(-2, math.MaxInt64)
+	L.RawGeti(-1, math.MaxInt64)
+	if L.ToString(-1) != "big" {
+		t.Fatal("Large integer key not preserved")
+	}
+}
+
+func TestGoStructNumberFields(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	type numbers struct {
+		I8  int8
+		U   uint64
+		F32 float32
+		I   int
+	}
+	v := &numbers{U: math.MaxUint64}
+	L.PushGoStruct(v)
+	L.SetGlobal("v")
+
+	if err := L.DoString("return v.U"); err == nil {
+		t.Fatal("Reading an uint64 field larger than a lua integer did not fail")
+	}
+	L.SetTop(0)
+	v.U = math.MaxInt64
+	if err := L.DoString("assert(math.type(v.I) == 'integer' and v.U == math.maxinteger); v.U = v.U"); err != nil {
+		t.Fatalf("Wrong field values: %v", err)
+	}
+	if v.U != math.MaxInt64 {
+		t.Fatalf("uint64 field not round-tripped: %d", v.U)
+	}
+	if err := L.DoString("v.I8 = 127; v.U = 2^63; v.I = 3.0; v.F32 = 1.5"); err != nil {
+		t.Fatalf("Valid assignment failed: %v", err)
+	}
+	if v.I8 != 127 || v.U != 1<<63 || v.I != 3 || v.F32 != 1.5 {
+		t.Fatalf("Fields not assigned: %+v", *v)
+	}
+	for _, code := range []string{"v.I8 = 128", "v.U = -1", "v.I = 1.5", "v.F32 = 1e300"} {
+		if err := L.DoString(code); err == nil {
+			t.Fatalf("Invalid assignment accepted <%s>: %+v", code, *v)
+		}
+	}
+}
+
 func TestDumpAndLoad(t *testing.T) {
 	L := NewState()
 	defer L.Close()
@@ -424,3 +498,209 @@ func TestDumpAndLoad(t *testing.T) {
 		t.Fatalf("Call error: %v", err)
 	}
 }
+
+func TestGoClosureUpvalues(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	counter := func(L *State) int {
+		n := L.ToInteger(L.UpvalueIndex(1)) + 1
+		L.PushInteger(n)
+		L.Replace(L.UpvalueIndex(1))
+		L.PushInteger(n)
+		L.PushString(L.ToString(L.UpvalueIndex(2)))
+		return 2
+	}
+
+	L.PushInteger(0)
+	L.PushString("prefix")
+	L.PushGoClosureN(counter, 2)
+	if L.GetTop() != 1 {
+		t.Fatalf("Upvalues were not popped from the stack (top: %d)", L.GetTop())
+	}
+	if !L.IsFunction(-1) {
+		t.Fatal("PushGoClosureN did not push a function")
+	}
+	L.SetGlobal("counter")
+
+	if err := L.DoString("counter(); return counter()"); err != nil {
+		t.Fatalf("Error calling counter: %v", err)
+	}
+	if n := L.ToInteger(-2); n != 2 {
+		t.Fatalf("Upvalue was not updated between calls (got %d)", n)
+	}
+	if s := L.ToString(-1); s != "prefix" {
+		t.Fatalf("Wrong second upvalue: <%s>", s)
+	}
+}
+
+func TestGoClosureInvalidUpvalues(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+
+	f := func(L *State) int { return 0 }
+	L.PushInteger(1)
+	for _, n := range []int{-1, 2, maxGoClosureUpvalues + 1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("No panic for %d upvalues", n)
+				}
+			}()
+			L.PushGoClosureN(f, n)
+		}()
+	}
+	if L.GetTop() != 1 {
+		t.Fatalf("Stack modified by invalid calls: %d values", L.GetTop())
+	}
+}
+
+func TestGoFunctionType(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	called := 0
+	f := func(L *State) int {
+		called++
+		return 0
+	}
+
+	L.Register("f", f)
+	if err := L.DoString("assert(type(f) == 'function')"); err != nil {
+		t.Fatalf("Registered Go function is not a lua function: %v", err)
+	}
+
+	L.GetGlobal("f")
+	if !L.IsFunction(-1) || !L.IsGoFunction(-1) {
+		t.Fatal("Registered Go function not recognized as a Go function")
+	}
+	if L.ToGoFunction(-1) == nil {
+		t.Fatal("Could not retrieve registered Go function")
+	}
+	L.Pop(1)
+
+	L.PushGoFunctionUserdata(f)
+	if !L.IsUserdata(-1) || !L.IsGoFunction(-1) {
+		t.Fatal("PushGoFunctionUserdata did not push a Go function userdata")
+	}
+	if err := L.Call(0, 0); err != nil {
+		t.Fatalf("Error calling Go function userdata: %v", err)
+	}
+
+	L.GetGlobal("print")
+	if L.IsGoFunction(-1) {
+		t.Fatal("C function recognized as a Go function")
+	}
+	L.Pop(1)
+
+	if err := L.DoString("f()"); err != nil {
+		t.Fatalf("Error calling registered Go function: %v", err)
+	}
+	if called != 2 {
+		t.Fatalf("Go function called %d times", called)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	L := NewStateLimit(1 << 20)
+	defer L.Close()
+	L.OpenLibs()
+
+	stats, ok := L.MemStats()
+	if !ok {
+		t.Fatal("No memory statistics for a State created with NewStateLimit")
+	}
+	if stats.Limit != 1<<20 || stats.Current == 0 || stats.Peak < stats.Current || stats.Allocations == 0 {
+		t.Fatalf("Wrong memory statistics: %+v", stats)
+	}
+
+	err := L.DoString("local t = {} for i = 1, 1e6 do t[i] = i end")
+	if err == nil {
+		t.Fatal("Exceeding the memory limit did not result in an error")
+	}
+	if le := err.(*LuaError); le.Code() != LUA_ERRMEM {
+		t.Fatalf("Wrong kind of error when exceeding the memory limit: %v (%d)", le, le.Code())
+	}
+
+	L.SetTop(0)
+	L.GC(LUA_GCCOLLECT, 0)
+	if !L.SetMemoryLimit(0) {
+		t.Fatal("Could not remove the memory limit")
+	}
+	if err := L.DoString("local t = {} for i = 1, 1e6 do t[i] = i end"); err != nil {
+		t.Fatalf("Error after removing the memory limit: %v", err)
+	}
+
+	L2 := NewState()
+	defer L2.Close()
+	if _, ok := L2.MemStats(); ok {
+		t.Fatal("Memory statistics returned for a State not created with NewStateLimit")
+	}
+}
+
+func TestMemoryLimitTooSmall(t *testing.T) {
+	if L := NewStateLimit(16); L != nil {
+		L.Close()
+		t.Fatal("NewStateLimit succeeded with a limit smaller than a state")
+	}
+}
+
+func TestPushEmptyBytes(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+
+	L.PushBytes(nil)
+	L.PushBytes([]byte{})
+	L.PushString("")
+	for i := 1; i <= 3; i++ {
+		if !L.IsString(i) || L.ToString(i) != "" {
+			t.Fatalf("Value %d is not an empty string", i)
+		}
+	}
+
+	// keys aren't NUL terminated anymore
+	L.NewTable()
+	L.PushInteger(1)
+	L.SetField(-2, "a\x00b")
+	L.GetField(-1, "a")
+	if !L.IsNil(-1) {
+		t.Fatal("Key truncated at NUL")
+	}
+}
+
+func BenchmarkPushString(b *testing.B) {
+	L := NewState()
+	defer L.Close()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		L.PushString("some string value")
+		L.Pop(1)
+	}
+}
+
+func BenchmarkSetGetField(b *testing.B) {
+	L := NewState()
+	defer L.Close()
+	L.NewTable()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		L.PushInteger(int64(i))
+		L.SetField(-2, "field")
+		L.GetField(-1, "field")
+		L.Pop(1)
+	}
+}
+
+func BenchmarkSetGetGlobal(b *testing.B) {
+	L := NewState()
+	defer L.Close()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		L.PushInteger(int64(i))
+		L.SetGlobal("global")
+		L.GetGlobal("global")
+		L.Pop(1)
+	}
+}
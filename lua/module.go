@@ -0,0 +1,100 @@
+package lua
+
+//#include <lua.h>
+//#include <lauxlib.h>
+//#include <stdlib.h>
+//#include "golua.h"
+import "C"
+
+import (
+	"sort"
+	"unsafe"
+)
+
+// Makes require(name) return a table containing funcs.
+//
+// The module is installed in package.preload, so it is only built the first time it is required and can be registered before the package library is opened.
+func (L *State) RegisterModule(name string, funcs map[string]LuaGoFunction) {
+	names := make([]string, 0, len(funcs))
+	for fname := range funcs {
+		names = append(names, fname)
+	}
+	sort.Strings(names)
+
+	L.RegisterModuleLoader(name, func(L *State) int {
+		L.CreateTable(0, len(names))
+		for _, fname := range names {
+			L.PushGoFunction(funcs[fname])
+			L.SetField(-2, fname)
+		}
+		return 1
+	})
+}
+
+// Installs loader in package.preload, require(name) calls it with the module name as first argument and returns its first result (true if it returns nothing).
+func (L *State) RegisterModuleLoader(name string, loader LuaGoFunction) {
+	L.getPreload()
+	L.PushGoFunction(loader)
+	L.SetField(-2, name)
+	L.Pop(1)
+}
+
+// Pushes the package.preload table, creating it if the package library isn't open yet
+func (L *State) getPreload() {
+	Cname := C.CString("_PRELOAD")
+	defer C.free(unsafe.Pointer(Cname))
+	C.luaL_getsubtable(L.state(), LUA_REGISTRYINDEX, Cname)
+}
+
+// Pushes package.searchers, returns false and pushes nothing if the package library isn't open
+func (L *State) getSearchers() bool {
+	L.GetField(LUA_REGISTRYINDEX, "_LOADED")
+	L.GetField(-1, "package")
+	L.Remove(-2)
+	if !L.IsTable(-1) {
+		L.Pop(1)
+		return false
+	}
+	L.GetField(-1, C.GOLUA_SEARCHERS)
+	L.Remove(-2)
+	if !L.IsTable(-1) {
+		L.Pop(1)
+		return false
+	}
+	return true
+}
+
+// Appends searcher to package.searchers, returns false if the package library isn't open.
+//
+// Require calls the searchers in order with the module name as their argument. A searcher that can't find the module returns a string explaining why
+// (conventionally "\n\tno ..."), otherwise it returns the loader of the module (a function) and an extra value passed to the loader as its second argument.
+func (L *State) AddSearcher(searcher LuaGoFunction) bool {
+	if !L.getSearchers() {
+		return false
+	}
+	L.PushGoFunction(searcher)
+	L.RawSeti(-2, int64(L.ObjLen(-2))+1)
+	L.Pop(1)
+	return true
+}
+
+// Inserts searcher at position pos of package.searchers (1 is tried first, before package.preload), see AddSearcher.
+// Returns false if the package library isn't open or pos is out of range.
+func (L *State) InsertSearcher(pos int, searcher LuaGoFunction) bool {
+	if !L.getSearchers() {
+		return false
+	}
+	n := int64(L.ObjLen(-1))
+	if pos < 1 || int64(pos) > n+1 {
+		L.Pop(1)
+		return false
+	}
+	for i := n; i >= int64(pos); i-- {
+		L.RawGeti(-1, i)
+		L.RawSeti(-2, i+1)
+	}
+	L.PushGoFunction(searcher)
+	L.RawSeti(-2, int64(pos))
+	L.Pop(1)
+	return true
+}
@@ -0,0 +1,54 @@
+package lua
+
+import "testing"
+
+func TestRegisterModule(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+
+	// modules can be registered before the package library is opened
+	L.RegisterModule("mylib", map[string]LuaGoFunction{
+		"add": func(L *State) int {
+			L.PushInteger(L.ToInteger(1) + L.ToInteger(2))
+			return 1
+		},
+	})
+	L.OpenLibs()
+	L.RegisterModuleLoader("other", func(L *State) int {
+		L.PushString(L.ToString(1))
+		return 1
+	})
+
+	if err := L.DoString("assert(require('mylib').add(1, 2) == 3); assert(require('mylib') == require('mylib')); assert(require('other') == 'other')"); err != nil {
+		t.Fatalf("Error requiring go modules: %v", err)
+	}
+}
+
+func TestSearcher(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	searcher := func(L *State) int {
+		if L.ToString(1) != "virtual" {
+			L.PushString("\n\tno virtual module '" + L.ToString(1) + "'")
+			return 1
+		}
+		L.PushGoFunction(func(L *State) int {
+			L.PushString(L.ToString(2))
+			return 1
+		})
+		L.PushString("extra")
+		return 2
+	}
+	if !L.AddSearcher(searcher) || !L.InsertSearcher(1, searcher) {
+		t.Fatal("Searchers not installed")
+	}
+
+	if err := L.DoString("assert(require('virtual') == 'extra'); assert(#package.searchers == 6)"); err != nil {
+		t.Fatalf("Error requiring module from go searcher: %v", err)
+	}
+	if err := L.DoString("local ok, err = unsafe_pcall(require, 'missing'); assert(not ok and err:find('no virtual module'))"); err != nil {
+		t.Fatalf("Searcher message not reported: %v", err)
+	}
+}
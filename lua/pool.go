@@ -0,0 +1,251 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Error returned by Pool.Get once the pool is closed
+var ErrPoolClosed = errors.New("lua: pool closed")
+
+// Registry keys of the copies of the globals and of package.loaded taken when a State enters a Pool
+const (
+	poolGlobalsKey = "_GOLUA_POOL_GLOBALS"
+	poolLoadedKey  = "_GOLUA_POOL_LOADED"
+)
+
+// Configuration of a Pool
+type PoolOptions struct {
+	// Creates a new State and prepares it (opening libraries, running prelude scripts, ...), required.
+	// The globals and loaded modules present when New returns are restored every time the State is returned to the pool.
+	// A State returned along with an error is closed.
+	New func() (*State, error)
+	// Maximum number of States, idle or in use, Get blocks when it is reached. 0 means no limit.
+	MaxSize int
+	// Maximum number of idle States kept by the pool, States returned past this number are closed. 0 means no limit.
+	MaxIdle int
+	// States whose lua heap is larger than MaxMemory bytes after being reset are closed instead of being reused. 0 means no limit.
+	MaxMemory uint64
+	// Called on the reset State when it is returned to the pool, returning false closes it instead of reusing it. Optional.
+	Validate func(L *State) bool
+}
+
+// A Pool hands out prepared States to goroutines, it can be used concurrently.
+//
+// A State obtained with Get belongs to the calling goroutine until it is returned with Put, which resets it:
+// the stack is emptied, the instruction budget, the execution limit and any pending interruption are removed,
+// globals and modules of package.loaded added, removed or replaced since the State was created are restored
+// (the contents of library tables are not) and a full garbage collection is run.
+type Pool struct {
+	opts PoolOptions
+	// limits the number of States, nil if there is no limit
+	sem chan struct{}
+
+	mu     sync.Mutex
+	idle   []*State
+	inUse  map[*State]struct{}
+	closed bool
+}
+
+// Creates a new Pool, States are created on demand by Get.
+func NewPool(opts PoolOptions) *Pool {
+	p := &Pool{opts: opts, inUse: make(map[*State]struct{})}
+	if opts.MaxSize > 0 {
+		p.sem = make(chan struct{}, opts.MaxSize)
+	}
+	return p
+}
+
+// Returns an idle State or creates a new one, waiting for a State to be returned if the pool is full.
+func (p *Pool) Get(ctx context.Context) (*State, error) {
+	if p.sem != nil {
+		select {
+		case p.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.release()
+		return nil, ErrPoolClosed
+	}
+	if n := len(p.idle); n > 0 {
+		L := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.inUse[L] = struct{}{}
+		p.mu.Unlock()
+		return L, nil
+	}
+	p.mu.Unlock()
+
+	L, err := p.newState()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.inUse[L] = struct{}{}
+	p.mu.Unlock()
+	return L, nil
+}
+
+// Creates a State with New, releasing its slot if New fails or panics
+func (p *Pool) newState() (L *State, err error) {
+	created := false
+	defer func() {
+		if !created {
+			p.release()
+		}
+	}()
+	L, err = p.opts.New()
+	if err != nil {
+		// the State is not used, New may return it along with the error
+		if L != nil {
+			L.Close()
+		}
+		return nil, err
+	}
+	L.snapshotGlobals()
+	created = true
+	return L, nil
+}
+
+// Returns L to the pool. If err is not nil, the reset fails or the pool is closed, L is closed instead of being reused.
+//
+// Panics if L was not obtained from Get or was already returned.
+func (p *Pool) Put(L *State, err error) {
+	p.mu.Lock()
+	if _, ok := p.inUse[L]; !ok {
+		p.mu.Unlock()
+		panic("lua: Pool.Put called with a State that is not in use, it was already returned or not obtained from Get")
+	}
+	delete(p.inUse, L)
+	p.mu.Unlock()
+
+	defer p.release()
+	if err != nil || !p.reset(L) {
+		L.Close()
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed || (p.opts.MaxIdle > 0 && len(p.idle) >= p.opts.MaxIdle) {
+		p.mu.Unlock()
+		L.Close()
+		return
+	}
+	p.idle = append(p.idle, L)
+	p.mu.Unlock()
+}
+
+// Closes the idle States, States in use are closed when they are returned.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, L := range idle {
+		L.Close()
+	}
+}
+
+func (p *Pool) release() {
+	if p.sem != nil {
+		<-p.sem
+	}
+}
+
+// Prepares L to be reused, returns false if it must be evicted
+func (p *Pool) reset(L *State) bool {
+	L.SetTop(0)
+	L.interrupt.Store(nil)
+	L.budget, L.budgetUsed, L.budgetStepSize = 0, 0, 0
+	L.executionLimit = 0
+	L.resetHook()
+	L.restoreGlobals()
+	L.CollectGarbage()
+	if p.opts.MaxMemory > 0 && L.MemoryUsage() > p.opts.MaxMemory {
+		return false
+	}
+	return p.opts.Validate == nil || p.opts.Validate(L)
+}
+
+// Saves a shallow copy of the globals and of package.loaded in the registry
+func (L *State) snapshotGlobals() {
+	L.RawGeti(LUA_REGISTRYINDEX, LUA_RIDX_GLOBALS)
+	L.snapshotTable(poolGlobalsKey)
+	L.GetField(LUA_REGISTRYINDEX, "_LOADED")
+	L.snapshotTable(poolLoadedKey)
+}
+
+// Restores the globals and package.loaded saved by snapshotGlobals
+func (L *State) restoreGlobals() {
+	L.RawGeti(LUA_REGISTRYINDEX, LUA_RIDX_GLOBALS)
+	L.restoreTable(poolGlobalsKey)
+	L.GetField(LUA_REGISTRYINDEX, "_LOADED")
+	L.restoreTable(poolLoadedKey)
+}
+
+// Pops the table at the top of the stack and saves a shallow copy of it in the registry at key
+func (L *State) snapshotTable(key string) {
+	if !L.IsTable(-1) {
+		L.Pop(1)
+		return
+	}
+	L.NewTable()
+	L.PushNil()
+	for L.Next(-3) != 0 {
+		L.PushValue(-2)
+		L.Insert(-2)
+		L.RawSet(-4)
+	}
+	L.SetField(LUA_REGISTRYINDEX, key)
+	L.Pop(1)
+}
+
+// Pops the table at the top of the stack and restores its contents from the copy saved at key by snapshotTable
+func (L *State) restoreTable(key string) {
+	L.GetField(LUA_REGISTRYINDEX, key)
+	if !L.IsTable(-1) || !L.IsTable(-2) {
+		L.Pop(2)
+		return
+	}
+	snapshot := L.GetTop()
+	table := snapshot - 1
+
+	// remove the keys added since the snapshot, keys can't be cleared while traversing the table
+	L.NewTable()
+	added := L.GetTop()
+	var n int64
+	L.PushNil()
+	for L.Next(table) != 0 {
+		L.Pop(1)
+		L.PushValue(-1)
+		L.RawGet(snapshot)
+		isNew := L.IsNil(-1)
+		L.Pop(1)
+		if isNew {
+			n++
+			L.PushValue(-1)
+			L.RawSeti(added, n)
+		}
+	}
+	for i := int64(1); i <= n; i++ {
+		L.RawGeti(added, i)
+		L.PushNil()
+		L.RawSet(table)
+	}
+
+	L.PushNil()
+	for L.Next(snapshot) != 0 {
+		L.PushValue(-2)
+		L.Insert(-2)
+		L.RawSet(table)
+	}
+	L.Pop(3)
+}
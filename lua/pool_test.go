@@ -0,0 +1,158 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	created := 0
+	p := NewPool(PoolOptions{
+		New: func() (*State, error) {
+			created++
+			L := NewState()
+			L.OpenLibs()
+			if err := L.DoString("prelude = 1"); err != nil {
+				return nil, err
+			}
+			return L, nil
+		},
+		MaxSize: 1,
+	})
+	defer p.Close()
+
+	ctx := context.Background()
+	L, err := p.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := L.DoString("prelude = 2; leaked = true; print = nil"); err != nil {
+		t.Fatalf("Error running script: %v", err)
+	}
+	L.PushInteger(1)
+
+	// the pool is full until the State is returned
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Get(short); err != context.DeadlineExceeded {
+		t.Fatalf("Get didn't wait for a free State: %v", err)
+	}
+
+	p.Put(L, nil)
+	L, err = p.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if created != 1 {
+		t.Fatalf("State not reused: %d created", created)
+	}
+	if L.GetTop() != 0 {
+		t.Fatal("Stack not reset")
+	}
+	if err := L.DoString("assert(prelude == 1 and leaked == nil and print)"); err != nil {
+		t.Fatalf("Globals not restored: %v", err)
+	}
+
+	// States that errored are evicted
+	p.Put(L, errors.New("failed"))
+	L, err = p.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if created != 2 {
+		t.Fatal("Errored State reused")
+	}
+	p.Put(L, nil)
+}
+
+func TestPoolResetsState(t *testing.T) {
+	p := NewPool(PoolOptions{
+		New: func() (*State, error) {
+			L := NewState()
+			L.OpenLibs()
+			return L, nil
+		},
+		MaxSize: 1,
+	})
+	defer p.Close()
+
+	ctx := context.Background()
+	L, err := p.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := L.DoString("package.loaded.leaked = {}; package.loaded.string = nil"); err != nil {
+		t.Fatalf("Error running script: %v", err)
+	}
+	L.SetInstructionBudget(10)
+	L.SetExecutionLimit(10)
+	L.Interrupt(nil)
+	p.Put(L, nil)
+
+	L, err = p.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if _, ok := L.InstructionBudget(); ok {
+		t.Fatal("Instruction budget not removed")
+	}
+	if err := L.DoString("for i = 1, 1000 do end assert(package.loaded.leaked == nil and package.loaded.string == string)"); err != nil {
+		t.Fatalf("State not reset: %v", err)
+	}
+	p.Put(L, nil)
+}
+
+func TestPoolNewFailure(t *testing.T) {
+	var failed *State
+	panics := true
+	p := NewPool(PoolOptions{
+		New: func() (*State, error) {
+			if panics {
+				panic("broken prelude")
+			}
+			failed = NewState()
+			return failed, failed.DoString("error('prelude failed')")
+		},
+		MaxSize: 1,
+	})
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	func() {
+		defer func() { recover() }()
+		p.Get(ctx)
+	}()
+
+	// the slot of the State whose creation panicked was released
+	panics = false
+	if _, err := p.Get(ctx); err == nil || err == context.DeadlineExceeded {
+		t.Fatalf("Error of New not returned: %v", err)
+	}
+	if !failed.isClosed() {
+		t.Fatal("State returned by a failed New not closed")
+	}
+}
+
+func TestPoolDoublePut(t *testing.T) {
+	p := NewPool(PoolOptions{
+		New:     func() (*State, error) { return NewState(), nil },
+		MaxSize: 1,
+	})
+	defer p.Close()
+
+	L, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	p.Put(L, nil)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Second Put of a State did not panic")
+		}
+	}()
+	p.Put(L, nil)
+}
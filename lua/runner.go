@@ -0,0 +1,265 @@
+package lua
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Error returned for jobs submitted to a Runner after it was shut down
+var ErrRunnerClosed = errors.New("lua: runner closed")
+
+// Error returned when a job calls Submit, Do or Shutdown on the Runner executing it, which would wait for itself forever
+var ErrRunnerReentrant = errors.New("lua: runner called from one of its own jobs")
+
+// A Runner owns a State on a dedicated goroutine, locked to its OS thread, and executes the jobs submitted by other goroutines one at a time.
+//
+// Jobs get exclusive access to the State while they run, they must not keep references to it after they return.
+// A job can't submit other jobs to its Runner nor shut it down, these calls return ErrRunnerReentrant.
+type Runner struct {
+	jobs chan *runnerJob
+	// closed once the goroutine owning the State exits
+	done chan struct{}
+	// id of the goroutine owning the State
+	worker atomic.Uint64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+type runnerJob struct {
+	ctx    context.Context
+	f      func(L *State) (interface{}, error)
+	future *Future
+}
+
+// The pending result of a job submitted to a Runner
+type Future struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// Returns a channel closed once the job has completed
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Returns the error of the job, only valid once Done is closed
+func (f *Future) Err() error {
+	return f.err
+}
+
+// Returns the value returned by a job submitted with SubmitValue, only valid once Done is closed
+func (f *Future) Value() interface{} {
+	return f.value
+}
+
+// Waits for the job to complete and returns its error, or returns ctx.Err() if ctx is done first (the job still runs).
+func (f *Future) Wait(ctx context.Context) error {
+	_, err := f.Result(ctx)
+	return err
+}
+
+// Like Wait but also returns the value returned by a job submitted with SubmitValue.
+func (f *Future) Result(ctx context.Context) (interface{}, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func resolvedFuture(err error) *Future {
+	f := &Future{done: make(chan struct{}), err: err}
+	close(f.done)
+	return f
+}
+
+// Returns the id of the calling goroutine, read from the header of its stack trace ("goroutine 18 [running]:")
+func goroutineID() uint64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	fields := bytes.Fields(buf[:n])
+	if len(fields) < 2 {
+		return 0
+	}
+	id, _ := strconv.ParseUint(string(fields[1]), 10, 64)
+	return id
+}
+
+// Returns true if the caller is a job of r
+func (r *Runner) reentrant() bool {
+	id := goroutineID()
+	return id != 0 && id == r.worker.Load()
+}
+
+// Starts a Runner whose State is created by setup on the goroutine of the Runner, returns the error of setup if it fails.
+// A State returned by setup along with an error is closed.
+//
+// queue is the number of jobs that can be submitted without blocking while another job is running.
+func NewRunner(setup func() (*State, error), queue int) (*Runner, error) {
+	r := &Runner{
+		jobs: make(chan *runnerJob, queue),
+		done: make(chan struct{}),
+	}
+	ready := make(chan error, 1)
+	go r.run(setup, ready)
+	if err := <-ready; err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Runner) run(setup func() (*State, error), ready chan<- error) {
+	defer close(r.done)
+	r.worker.Store(goroutineID())
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	L, err := runnerSetup(setup)
+	ready <- err
+	if err != nil {
+		return
+	}
+	defer L.Close()
+	for job := range r.jobs {
+		runJob(L, job)
+	}
+}
+
+func runnerSetup(setup func() (*State, error)) (L *State, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			L, err = nil, panicError(r)
+		}
+	}()
+	L, err = setup()
+	if err != nil && L != nil {
+		// the State is not used, setup may return it along with the error
+		L.Close()
+		L = nil
+	}
+	return L, err
+}
+
+// Converts a recovered panic into an error
+func panicError(r interface{}) error {
+	if err, ok := r.(error); ok {
+		return fmt.Errorf("lua: panic in runner: %w", err)
+	}
+	return fmt.Errorf("lua: panic in runner: %v", r)
+}
+
+// Executes job, interrupting the lua code it runs if its context is cancelled
+func runJob(L *State, job *runnerJob) {
+	defer close(job.future.done)
+	if err := job.ctx.Err(); err != nil {
+		job.future.err = err
+		return
+	}
+
+	stop := make(chan struct{})
+	interrupted := make(chan *interruptRequest, 1)
+	go func() {
+		select {
+		case <-job.ctx.Done():
+			interrupted <- L.interruptWith(job.ctx.Err())
+		case <-stop:
+			interrupted <- nil
+		}
+	}()
+
+	job.future.value, job.future.err = callJob(L, job.f)
+	close(stop)
+	if req := <-interrupted; req != nil {
+		// the context could have been cancelled after the lua code returned
+		L.clearInterrupt(req)
+	}
+}
+
+func callJob(L *State, f func(L *State) (interface{}, error)) (value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			value, err = nil, panicError(r)
+		}
+	}()
+	return f(L)
+}
+
+// Queues f for execution on the State of the Runner and returns its pending result.
+//
+// If ctx is done before f starts, f is skipped and its result is ctx.Err(), if ctx is done while f runs the lua code it executes is interrupted (see Interrupt).
+func (r *Runner) Submit(ctx context.Context, f func(L *State) error) *Future {
+	return r.SubmitValue(ctx, func(L *State) (interface{}, error) {
+		return nil, f(L)
+	})
+}
+
+// Like Submit for a job returning a value, which is available from the Future once the job has completed (see Future.Result).
+func (r *Runner) SubmitValue(ctx context.Context, f func(L *State) (interface{}, error)) *Future {
+	if r.reentrant() {
+		return resolvedFuture(ErrRunnerReentrant)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return resolvedFuture(ErrRunnerClosed)
+	}
+	job := &runnerJob{ctx, f, &Future{done: make(chan struct{})}}
+	select {
+	case r.jobs <- job:
+		return job.future
+	case <-ctx.Done():
+		return resolvedFuture(ctx.Err())
+	}
+}
+
+// Executes f on the State of the Runner and returns its error, panics raised by f are returned as errors.
+func (r *Runner) Do(f func(L *State) error) error {
+	return r.DoContext(context.Background(), f)
+}
+
+// Like Do but gives up when ctx is done, see Submit.
+func (r *Runner) DoContext(ctx context.Context, f func(L *State) error) error {
+	return r.Submit(ctx, f).Wait(ctx)
+}
+
+// Executes f on the State of the Runner and returns its result, see Do.
+func (r *Runner) DoValue(f func(L *State) (interface{}, error)) (interface{}, error) {
+	ctx := context.Background()
+	return r.SubmitValue(ctx, f).Result(ctx)
+}
+
+// Stops accepting jobs and waits until the queued jobs have run and the State is closed, or until ctx is done.
+//
+// Returns ErrRunnerReentrant without stopping the Runner when called from one of its jobs.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	if r.reentrant() {
+		return ErrRunnerReentrant
+	}
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.jobs)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Like Shutdown without a deadline.
+func (r *Runner) Close() {
+	r.Shutdown(context.Background())
+}
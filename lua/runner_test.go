@@ -0,0 +1,123 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunner(t *testing.T) {
+	r, err := NewRunner(func() (*State, error) {
+		L := NewState()
+		L.OpenLibs()
+		return L, L.DoString("counter = 0")
+	}, 4)
+	if err != nil {
+		t.Fatalf("Error starting runner: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.Do(func(L *State) error { return L.DoString("counter = counter + 1") }); err != nil {
+				t.Errorf("Job failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var counter int64
+	r.Do(func(L *State) error {
+		L.GetGlobal("counter")
+		counter = L.ToInteger(-1)
+		L.Pop(1)
+		return nil
+	})
+	if counter != 10 {
+		t.Fatalf("Jobs not executed serially: %d", counter)
+	}
+
+	if err := r.Do(func(L *State) error { panic("boom") }); err == nil {
+		t.Fatal("Panic not reported")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = r.DoContext(ctx, func(L *State) error { return L.DoString("while true do end") })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Job not interrupted: %v", err)
+	}
+	if err := r.Do(func(L *State) error { return L.DoString("counter = 0") }); err != nil {
+		t.Fatalf("State not usable after interruption: %v", err)
+	}
+
+	r.Close()
+	if err := r.Do(func(L *State) error { return nil }); err != ErrRunnerClosed {
+		t.Fatalf("Job accepted after shutdown: %v", err)
+	}
+}
+
+func TestRunnerSetupError(t *testing.T) {
+	var L *State
+	_, err := NewRunner(func() (*State, error) {
+		L = NewState()
+		return L, L.DoString("error('setup failed')")
+	}, 0)
+	if err == nil {
+		t.Fatal("Error of setup not returned")
+	}
+	if !L.isClosed() {
+		t.Fatal("State returned by a failed setup not closed")
+	}
+}
+
+func TestRunnerValue(t *testing.T) {
+	r, err := NewRunner(func() (*State, error) {
+		L := NewState()
+		L.OpenLibs()
+		return L, nil
+	}, 0)
+	if err != nil {
+		t.Fatalf("Error starting runner: %v", err)
+	}
+	defer r.Close()
+
+	v, err := r.DoValue(func(L *State) (interface{}, error) {
+		if err := L.DoString("return 6 * 7"); err != nil {
+			return nil, err
+		}
+		return L.ToInteger(-1), nil
+	})
+	if err != nil || v != int64(42) {
+		t.Fatalf("Wrong result of job: %v %v", v, err)
+	}
+}
+
+func TestRunnerReentrant(t *testing.T) {
+	r, err := NewRunner(func() (*State, error) { return NewState(), nil }, 1)
+	if err != nil {
+		t.Fatalf("Error starting runner: %v", err)
+	}
+	defer r.Close()
+
+	err = r.Do(func(L *State) error {
+		if err := r.Do(func(L *State) error { return nil }); err != ErrRunnerReentrant {
+			return fmt.Errorf("Do from a job returned %v", err)
+		}
+		if err := r.Shutdown(context.Background()); err != ErrRunnerReentrant {
+			return fmt.Errorf("Shutdown from a job returned %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Do(func(L *State) error { return nil }); err != nil {
+		t.Fatalf("Runner not usable after a reentrant call: %v", err)
+	}
+}
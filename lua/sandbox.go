@@ -0,0 +1,292 @@
+package lua
+
+//#include <lua.h>
+//#include <lauxlib.h>
+//#include <stdlib.h>
+//#include "golua.h"
+import "C"
+
+import "strings"
+
+// A Sandbox describes the globals and library functions made available to untrusted code.
+//
+// Entries of Allow and Deny name either a global ("print"), a whole library ("string") or a single library function ("os.time").
+// Deny takes precedence over Allow, so that a library can be allowed with some of its functions removed.
+//
+// The harmless functions of the base library (assert, error, ipairs, pairs, type, ...) are always available,
+// everything else, including load, loadfile, dofile and require, is removed unless it is allowed explicitly.
+// This includes unsafe_pcall and unsafe_xpcall (see OpenLibs), so sandboxed code has no way to catch errors:
+// any error aborts the whole chunk.
+//
+// With LuaJIT the ffi library, which gives unrestricted access to the process, and the jit library are only available
+// when "ffi" and "jit" are allowed (ApplySandbox removes them from package.preload, NewSandboxEnv leaves package.preload untouched).
+type Sandbox struct {
+	Allow []string
+	Deny  []string
+}
+
+// Base library functions that are always available inside a sandbox
+var sandboxBase = []string{
+	"_G", "_VERSION", "assert", "error", "getmetatable", "ipairs", "next", "pairs",
+	"rawequal", "rawget", "rawlen", "rawset", "select", "setmetatable", "tonumber", "tostring", "type",
+	C.GOLUA_DEFAULT_MSGHANDLER,
+}
+
+// Libraries LuaJIT makes available through package.preload instead of globals
+var sandboxPreloaded = []string{"ffi", "jit.util", "jit.profile"}
+
+// Returns a sandbox allowing the pure lua libraries, print and the time functions of os.
+func DefaultSandbox() *Sandbox {
+	return &Sandbox{
+		Allow: []string{"print", "string", "table", "math", "utf8", "coroutine", "os.time", "os.clock", "os.date", "os.difftime"},
+		Deny:  []string{"string.dump"},
+	}
+}
+
+type sandboxFilter struct {
+	allow map[string]bool
+	deny  map[string]bool
+	// libraries with at least one function allowed
+	libs map[string]bool
+}
+
+func (sb *Sandbox) filter() *sandboxFilter {
+	f := &sandboxFilter{make(map[string]bool), make(map[string]bool), make(map[string]bool)}
+	for _, name := range sandboxBase {
+		f.allow[name] = true
+	}
+	for _, name := range sb.Allow {
+		f.allow[name] = true
+		if i := strings.Index(name, "."); i >= 0 {
+			f.libs[name[:i]] = true
+		}
+	}
+	for _, name := range sb.Deny {
+		f.deny[name] = true
+	}
+	return f
+}
+
+func (f *sandboxFilter) keepGlobal(name string) bool {
+	return (f.allow[name] || f.libs[name]) && !f.deny[name]
+}
+
+func (f *sandboxFilter) keepField(lib, field string) bool {
+	name := lib + "." + field
+	return (f.allow[lib] || f.allow[name]) && !f.deny[name]
+}
+
+func (f *sandboxFilter) keepPreloaded(name string) bool {
+	if i := strings.Index(name, "."); i >= 0 {
+		return f.keepField(name[:i], name[i+1:])
+	}
+	return f.keepGlobal(name)
+}
+
+// Returns true if the table stored in the global name must be filtered field by field
+func (f *sandboxFilter) isLibrary(name string) bool {
+	if name == "_G" {
+		return false
+	}
+	if f.libs[name] {
+		return true
+	}
+	for denied := range f.deny {
+		if strings.HasPrefix(denied, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the string keys of the table at index
+func (L *State) stringKeys(index int) []string {
+	index = L.AbsIndex(index)
+	keys := []string{}
+	L.PushNil()
+	for L.Next(index) != 0 {
+		if L.Type(-2) == LUA_TSTRING {
+			keys = append(keys, L.ToString(-2))
+		}
+		L.Pop(1)
+	}
+	return keys
+}
+
+// Opens all standard libraries (see OpenLibs) then restricts them with ApplySandbox.
+func (L *State) OpenSandbox(sb *Sandbox) {
+	L.OpenLibs()
+	L.ApplySandbox(sb)
+}
+
+// Removes from the global environment everything that sb doesn't allow.
+//
+// Library tables are modified in place, so that functions denied by the sandbox can't be reached
+// through package.loaded or the string metatable either, and the string metatable is hidden (see NewSandboxEnv).
+// Globals registered after the call to ApplySandbox are not affected.
+func (L *State) ApplySandbox(sb *Sandbox) {
+	f := sb.filter()
+
+	L.RawGeti(LUA_REGISTRYINDEX, LUA_RIDX_GLOBALS)
+	globals := L.GetTop()
+	for _, name := range L.stringKeys(globals) {
+		keep := f.keepGlobal(name)
+		if keep && f.isLibrary(name) {
+			L.PushString(name)
+			L.RawGet(globals)
+			if L.IsTable(-1) {
+				lib := L.GetTop()
+				kept := 0
+				for _, field := range L.stringKeys(lib) {
+					if f.keepField(name, field) {
+						kept++
+						continue
+					}
+					L.PushString(field)
+					L.PushNil()
+					L.RawSet(lib)
+				}
+				keep = kept > 0 || f.allow[name]
+			}
+			L.Pop(1)
+		}
+		if !keep {
+			L.PushString(name)
+			L.PushNil()
+			L.RawSet(globals)
+		}
+	}
+
+	// libraries removed from the globals must not be reachable through require either
+	L.GetField(LUA_REGISTRYINDEX, "_LOADED")
+	if L.IsTable(-1) {
+		loaded := L.GetTop()
+		for _, name := range L.stringKeys(loaded) {
+			L.PushString(name)
+			L.RawGet(globals)
+			removed := L.IsNil(-1) && !(isPreloaded(name) && f.keepPreloaded(name))
+			L.Pop(1)
+			if removed {
+				L.PushString(name)
+				L.PushNil()
+				L.RawSet(loaded)
+			}
+		}
+	}
+	L.Pop(1)
+
+	L.GetField(LUA_REGISTRYINDEX, "_PRELOAD")
+	if L.IsTable(-1) {
+		for _, name := range sandboxPreloaded {
+			if !f.keepPreloaded(name) {
+				L.PushString(name)
+				L.PushNil()
+				L.RawSet(-3)
+			}
+		}
+	}
+	L.Pop(2)
+
+	L.sandboxStringMetatable(f)
+}
+
+func isPreloaded(name string) bool {
+	for _, preloaded := range sandboxPreloaded {
+		if name == preloaded {
+			return true
+		}
+	}
+	return false
+}
+
+// Pushes a new table containing a copy of the globals allowed by sb, to be used as the environment of a chunk (see DoStringEnv).
+//
+// Tables are copied and library tables filtered, the global environment is left untouched except for the string metatable,
+// which is shared by every chunk: getmetatable returns false for strings, so that a chunk can't change the methods of strings
+// seen by the others, and the string functions denied by sb are no longer methods of strings (("").dump), in any environment.
+func (L *State) NewSandboxEnv(sb *Sandbox) {
+	f := sb.filter()
+	L.sandboxStringMetatable(f)
+
+	L.NewTable()
+	env := L.GetTop()
+	L.RawGeti(LUA_REGISTRYINDEX, LUA_RIDX_GLOBALS)
+	globals := L.GetTop()
+	for _, name := range L.stringKeys(globals) {
+		if !f.keepGlobal(name) || name == "_G" {
+			continue
+		}
+		L.PushString(name)
+		L.PushString(name)
+		L.RawGet(globals)
+		// tables are copied so that chunks can't modify each other's libraries
+		if L.IsTable(-1) {
+			lib := L.GetTop()
+			filtered := f.isLibrary(name)
+			L.NewTable()
+			L.PushNil()
+			for L.Next(lib) != 0 {
+				if filtered && (L.Type(-2) != LUA_TSTRING || !f.keepField(name, L.ToString(-2))) {
+					L.Pop(1)
+					continue
+				}
+				L.PushValue(-2)
+				L.Insert(-2)
+				L.RawSet(-4)
+			}
+			L.Remove(lib)
+		}
+		L.RawSet(env)
+	}
+	L.Pop(1)
+
+	L.PushString("_G")
+	L.PushValue(env)
+	L.RawSet(env)
+}
+
+// Hides the string metatable from lua code and removes the string functions denied by f from the methods of strings
+func (L *State) sandboxStringMetatable(f *sandboxFilter) {
+	L.PushString("")
+	if !L.GetMetaTable(-1) {
+		L.Pop(1)
+		return
+	}
+	mt := L.GetTop()
+	L.PushString("__index")
+	L.RawGet(mt)
+	if L.IsTable(-1) {
+		// the methods are copied, the string library itself may be allowed
+		index := L.GetTop()
+		L.NewTable()
+		L.PushNil()
+		for L.Next(index) != 0 {
+			if L.Type(-2) == LUA_TSTRING && f.deny["string."+L.ToString(-2)] {
+				L.Pop(1)
+				continue
+			}
+			L.PushValue(-2)
+			L.Insert(-2)
+			L.RawSet(-4)
+		}
+		L.PushString("__index")
+		L.Insert(-2)
+		L.RawSet(mt)
+	}
+	L.Pop(1)
+	L.PushString("__metatable")
+	L.PushBoolean(false)
+	L.RawSet(mt)
+	L.Pop(2)
+}
+
+// Executes the string using the table at index env as its environment (_ENV), returns nil for no errors or the lua error string on failure
+func (L *State) DoStringEnv(str string, env int) error {
+	env = L.AbsIndex(env)
+	if r := L.LoadString(str); r != 0 {
+		return &LuaError{r, L.ToString(-1), L.StackTrace()}
+	}
+	L.PushValue(env)
+	C.clua_setchunkenv(L.state(), -2)
+	return L.Call(0, LUA_MULTRET)
+}
@@ -0,0 +1,124 @@
+package lua
+
+import "testing"
+
+func TestSandbox(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenSandbox(DefaultSandbox())
+
+	allowed := []string{
+		"assert(type(os.time()) == 'number')",
+		"assert(string.format('%d', 1) == '1')",
+		"assert(table.concat({'a', 'b'}) == 'ab')",
+	}
+	for _, code := range allowed {
+		if err := L.DoString(code); err != nil {
+			t.Fatalf("Allowed code failed <%s>: %v", code, err)
+		}
+	}
+
+	denied := []string{
+		"assert(os.execute == nil)",
+		"assert(io == nil)",
+		"assert(load == nil and loadfile == nil and dofile == nil and require == nil)",
+		"assert(string.dump == nil and ('').dump == nil)",
+	}
+	for _, code := range denied {
+		if err := L.DoString(code); err != nil {
+			t.Fatalf("Denied function still reachable <%s>: %v", code, err)
+		}
+	}
+}
+
+func TestDoStringEnv(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	L.NewSandboxEnv(&Sandbox{Allow: []string{"os.time"}})
+	env := L.GetTop()
+
+	if err := L.DoStringEnv("x = 1; assert(os.time and not os.execute and not print)", env); err != nil {
+		t.Fatalf("Error executing chunk in sandbox environment: %v", err)
+	}
+
+	L.GetField(env, "x")
+	if L.ToInteger(-1) != 1 {
+		t.Fatal("Global not set in the chunk environment")
+	}
+	L.Pop(1)
+
+	L.GetGlobal("x")
+	if !L.IsNil(-1) {
+		t.Fatal("Chunk modified the global environment")
+	}
+	L.Pop(1)
+
+	if err := L.DoString("assert(os.execute)"); err != nil {
+		t.Fatalf("Global environment was restricted: %v", err)
+	}
+}
+
+func TestSandboxFFI(t *testing.T) {
+	for _, allowFFI := range []bool{false, true} {
+		L := NewState()
+		L.OpenLibs()
+		// stands in for the ffi library LuaJIT registers in package.preload
+		L.MustDoString("package.preload.ffi = function() return {} end")
+
+		sb := &Sandbox{Allow: []string{"require"}}
+		if allowFFI {
+			sb.Allow = append(sb.Allow, "ffi")
+		}
+		L.ApplySandbox(sb)
+
+		err := L.DoString("require('ffi')")
+		if allowFFI && err != nil {
+			t.Fatalf("Allowed ffi library not available: %v", err)
+		}
+		if !allowFFI && err == nil {
+			t.Fatal("ffi library available without being allowed")
+		}
+		L.Close()
+	}
+}
+
+func TestSandboxGoMetatables(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenSandbox(DefaultSandbox())
+
+	L.PushGoStruct(&TestStruct{IntField: 1})
+	L.SetGlobal("obj")
+	L.PushGoFunctionUserdata(func(L *State) int { return 0 })
+	L.SetGlobal("fn")
+
+	if err := L.DoString("assert(getmetatable(obj) == false and getmetatable(fn) == false)"); err != nil {
+		t.Fatalf("Metatable of Go values exposed: %v", err)
+	}
+	if err := L.DoString("getmetatable(obj).__gc(obj)"); err == nil {
+		t.Fatal("__gc of a Go value reachable from lua")
+	}
+	if err := L.DoString("assert(obj.IntField == 1)"); err != nil {
+		t.Fatalf("Go value not usable: %v", err)
+	}
+}
+
+func TestSandboxEnvStringMetatable(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	L.NewSandboxEnv(DefaultSandbox())
+	tenant1 := L.GetTop()
+	L.NewSandboxEnv(DefaultSandbox())
+	tenant2 := L.GetTop()
+
+	if err := L.DoStringEnv("getmetatable('').__index = { upper = function() return 'evil' end }", tenant1); err == nil {
+		t.Fatal("Chunk could change the string metatable")
+	}
+	if err := L.DoStringEnv("assert(('x'):upper() == 'X' and ('').dump == nil)", tenant2); err != nil {
+		t.Fatalf("String methods tampered with or string.dump reachable: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package lua
+
+//#include <lua.h>
+//#include <lauxlib.h>
+//#include <stdlib.h>
+//#include "golua.h"
+import "C"
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// Snapshot of the resources held by a State, see State.Stats
+type Stats struct {
+	// Number of go values referenced by the State (functions, structs, file handles, ...)
+	Registered int
+	// Go functions pushed with PushGoFunction, PushGoClosure and similar
+	Functions int
+	// Values pushed with PushGoStruct
+	Structs int
+	// Go values pushed as file handles (PushFile, SetFS, SetStdout, ...)
+	Streams int
+	// Number of referenced go values by go type
+	ByType map[string]int
+	// Size of the table of go values and number of its free slots
+	RegistrySize int
+	FreeIndices  int
+	// Size of the lua heap in bytes
+	HeapBytes uint64
+	// Number of live references created with Ref in the lua registry
+	Refs int
+	// Number of referenced go values by the call site that pushed them, only set when TrackPushSites is enabled
+	PushSites map[string]int
+}
+
+// Returns statistics about the go values and lua memory held by L, to track down leaks.
+func (L *State) Stats() Stats {
+	st := Stats{
+		ByType:       make(map[string]int),
+		RegistrySize: len(L.registry),
+		FreeIndices:  len(L.freeIndices),
+		HeapBytes:    L.MemoryUsage(),
+		Refs:         L.countRefs(),
+	}
+	if L.pushSites != nil {
+		st.PushSites = make(map[string]int)
+	}
+	for i, v := range L.registry {
+		if v == nil {
+			continue
+		}
+		st.Registered++
+		switch v.(type) {
+		case LuaGoFunction:
+			st.Functions++
+		case *goStream:
+			st.Streams++
+		default:
+			st.Structs++
+		}
+		st.ByType[reflect.TypeOf(v).String()]++
+		if st.PushSites != nil && i < len(L.pushSites) {
+			st.PushSites[L.pushSites[i]]++
+		}
+	}
+	return st
+}
+
+// Counts the references of the lua registry created by luaL_ref that have not been released
+func (L *State) countRefs() int {
+	L.PushValue(LUA_REGISTRYINDEX)
+	registry := L.GetTop()
+	used := 0
+	L.PushNil()
+	for L.Next(registry) != 0 {
+		L.Pop(1)
+		if L.IsInteger(-1) && L.ToInteger(-1) > C.LUA_RIDX_LAST && L.ToInteger(-1) != refFreelist {
+			used++
+		}
+	}
+	// released references are chained from the index refFreelist of the registry
+	free := 0
+	L.RawGeti(registry, refFreelist)
+	for next := L.ToInteger(-1); next > C.LUA_RIDX_LAST && free < used; next = L.ToInteger(-1) {
+		free++
+		L.Pop(1)
+		L.RawGeti(registry, next)
+	}
+	L.Pop(2)
+	return used - free
+}
+
+// Enables or disables the recording of the go call site that pushed each go value, reported in Stats.PushSites.
+// Recording slows pushes down and only covers the values pushed while it is enabled.
+func (L *State) TrackPushSites(enable bool) {
+	if !enable {
+		L.pushSites = nil
+	} else if L.pushSites == nil {
+		L.pushSites = make([]string, len(L.registry))
+	}
+}
+
+// Directory of the sources of this package, frames inside it are skipped when recording push sites
+var packageDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// Records the call site of the value registered at index
+func (L *State) recordPushSite(index uint) {
+	for uint(len(L.pushSites)) <= index {
+		L.pushSites = append(L.pushSites, "")
+	}
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	site := "unknown"
+	for {
+		frame, more := frames.Next()
+		if filepath.Dir(frame.File) != packageDir || strings.HasSuffix(frame.File, "_test.go") {
+			site = fmt.Sprintf("%s:%d", frame.File, frame.Line)
+			break
+		}
+		if !more {
+			break
+		}
+	}
+	L.pushSites[index] = site
+}
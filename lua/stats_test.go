@@ -0,0 +1,44 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+type statsStruct struct{ X int }
+
+func TestStats(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.TrackPushSites(true)
+
+	L.PushGoFunction(func(L *State) int { return 0 })
+	L.PushGoStruct(&statsStruct{})
+	L.PushInteger(1)
+	ref := L.Ref(LUA_REGISTRYINDEX)
+	L.PushInteger(2)
+	L.Unref(LUA_REGISTRYINDEX, L.Ref(LUA_REGISTRYINDEX))
+
+	st := L.Stats()
+	if st.Functions != 1 || st.Structs != 1 || st.Registered != 2 {
+		t.Fatalf("Wrong counts: %+v", st)
+	}
+	if st.ByType["*lua.statsStruct"] != 1 {
+		t.Fatalf("Wrong counts by type: %v", st.ByType)
+	}
+	if st.Refs != 1 {
+		t.Fatalf("Wrong number of refs: %d", st.Refs)
+	}
+	if st.HeapBytes == 0 {
+		t.Fatal("Heap size not reported")
+	}
+	for site, n := range st.PushSites {
+		if !strings.Contains(site, "stats_test.go") || n != 1 {
+			t.Fatalf("Wrong push site: %s %d", site, n)
+		}
+	}
+	if len(st.PushSites) != 2 {
+		t.Fatalf("Wrong push sites: %v", st.PushSites)
+	}
+	L.Unref(LUA_REGISTRYINDEX, ref)
+}
@@ -0,0 +1,101 @@
+package lua
+
+//#include <lua.h>
+//#include <lauxlib.h>
+//#include <stdlib.h>
+//#include "golua.h"
+import "C"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Redirects the standard output of L to w: print, io.write and io.stdout write to w, a nil w discards the output.
+//
+// Like SetFS it replaces the functions of the opened libraries, so it must be called after they are opened.
+// Writes are not buffered, each call to print or io.write results in calls to w.Write, print raises a lua error if w.Write fails.
+//
+// Returns ErrGoStreamUnsupported, after redirecting print, if the build can't redirect the io library (LuaJIT, Windows).
+func (L *State) SetStdout(w io.Writer) error {
+	if w == nil {
+		w = io.Discard
+	}
+	L.stdout = w
+	L.RawGeti(LUA_REGISTRYINDEX, LUA_RIDX_GLOBALS)
+	L.replaceField(-1, "print", goPrint)
+	L.Pop(1)
+	return L.setStdStream("stdout", "_IO_output", &goStream{w: w})
+}
+
+// Redirects io.stderr to w, a nil w discards the output.
+//
+// Returns ErrGoStreamUnsupported if the build can't redirect the io library (LuaJIT, Windows).
+func (L *State) SetStderr(w io.Writer) error {
+	if w == nil {
+		w = io.Discard
+	}
+	return L.setStdStream("stderr", "", &goStream{w: w})
+}
+
+// Makes io.read, io.lines and io.stdin read from r, a nil r behaves like an empty input.
+//
+// Returns ErrGoStreamUnsupported if the build can't redirect the io library (LuaJIT, Windows).
+func (L *State) SetStdin(r io.Reader) error {
+	if r == nil {
+		r = strings.NewReader("")
+	}
+	return L.setStdStream("stdin", "_IO_input", &goStream{r: r})
+}
+
+// Replaces the standard file handle name of the io library, and the default file stored in the registry at key if any.
+// Does nothing if the io library is not opened.
+func (L *State) setStdStream(name string, key string, st *goStream) error {
+	if C.clua_hasgostream() == 0 {
+		return ErrGoStreamUnsupported
+	}
+	L.GetField(LUA_REGISTRYINDEX, "_LOADED")
+	L.GetField(-1, "io")
+	L.Remove(-2)
+	if !L.IsTable(-1) {
+		L.Pop(1)
+		return nil
+	}
+	if !L.pushGoStream(st, false) {
+		L.Pop(2)
+		return fmt.Errorf("lua: could not redirect io.%s", name)
+	}
+	if key != "" {
+		L.PushValue(-1)
+		L.SetField(LUA_REGISTRYINDEX, key)
+	}
+	L.SetField(-2, name)
+	L.Pop(1)
+	return nil
+}
+
+// print (...), writing to the writer set with SetStdout
+func goPrint(L *State) int {
+	var buf bytes.Buffer
+	n := L.GetTop()
+	for i := 1; i <= n; i++ {
+		L.GetGlobal("tostring")
+		L.PushValue(i)
+		L.MustCall(1, 1)
+		if L.Type(-1) != LUA_TSTRING {
+			L.RaiseError("'tostring' must return a string to 'print'")
+		}
+		if i > 1 {
+			buf.WriteByte('\t')
+		}
+		buf.Write(L.ToBytes(-1))
+		L.Pop(1)
+	}
+	buf.WriteByte('\n')
+	if _, err := L.stdout.Write(buf.Bytes()); err != nil {
+		L.RaiseError("error writing to 'print' output: " + err.Error())
+	}
+	return 0
+}
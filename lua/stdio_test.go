@@ -0,0 +1,62 @@
+package lua
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStdio(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	var stdout, stderr bytes.Buffer
+	for _, err := range []error{L.SetStdout(&stdout), L.SetStderr(&stderr), L.SetStdin(strings.NewReader("line one\n42\n"))} {
+		if err == ErrGoStreamUnsupported {
+			t.Skip("standard streams can't be redirected by this build")
+		}
+		if err != nil {
+			t.Fatalf("Could not redirect the standard streams: %v", err)
+		}
+	}
+
+	err := L.DoString(`
+		print("hello", 1, nil)
+		io.write("a", 2, "\n")
+		io.stdout:write("b\n")
+		io.stderr:write("oops\n")
+		assert(io.read("l") == "line one")
+		assert(io.stdin:read("n") == 42)
+		local ok, err = io.stdout:close()
+		assert(not ok and err == "cannot close standard file")
+	`)
+	if err != nil {
+		t.Fatalf("Error using standard streams: %v", err)
+	}
+	if stdout.String() != "hello\t1\tnil\na2\nb\n" {
+		t.Fatalf("Wrong standard output: %q", stdout.String())
+	}
+	if stderr.String() != "oops\n" {
+		t.Fatalf("Wrong standard error: %q", stderr.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestPrintWriteError(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	L.SetStdout(failingWriter{})
+	err := L.DoString(`print("hello")`)
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("Write error of print not raised: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package lua
+
+//#include <lua.h>
+//#include <lauxlib.h>
+//#include <stdlib.h>
+//#include "golua.h"
+import "C"
+
+import (
+	"errors"
+	"io"
+	"unsafe"
+)
+
+// Error returned when redirecting a standard stream of the io library with a build that can't create file handles backed by Go values:
+// LuaJIT builds, and platforms other than Linux, macOS and FreeBSD such as Windows.
+var ErrGoStreamUnsupported = errors.New("lua: file handles backed by Go streams are not supported by this build")
+
+// A go value backing a file handle of the io library
+type goStream struct {
+	r io.Reader
+	w io.Writer
+	s io.Seeker
+	// nil for streams that must not be closed by lua
+	c io.Closer
+}
+
+func newGoStream(v interface{}) *goStream {
+	st := &goStream{}
+	st.r, _ = v.(io.Reader)
+	st.w, _ = v.(io.Writer)
+	st.s, _ = v.(io.Seeker)
+	st.c, _ = v.(io.Closer)
+	return st
+}
+
+// Returns the fopen mode matching the capabilities of the stream
+func (st *goStream) mode() string {
+	switch {
+	case st.r != nil && st.w != nil:
+		return "r+"
+	case st.w != nil:
+		return "w"
+	default:
+		return "r"
+	}
+}
+
+// Pushes a file handle of the io library reading from and writing to rw, that must implement io.Reader, io.Writer or both.
+//
+// The handle supports the methods of lua files (read, write, lines, seek, close, ...) according to the interfaces implemented by rw:
+// seek requires io.Seeker, and rw is closed by close or when the handle is collected if it implements io.Closer.
+// A handle wrapping both a reader and a writer is opened in "r+" mode, writes are buffered until flush, seek or close like any lua file.
+// Returns false and pushes nil if rw is neither a reader nor a writer or the handle couldn't be created,
+// which is always the case with LuaJIT and on platforms other than Linux, macOS and FreeBSD (Windows).
+func (L *State) PushFile(rw interface{}) bool {
+	st := newGoStream(rw)
+	if st.r == nil && st.w == nil {
+		L.PushNil()
+		return false
+	}
+	if !L.pushGoStream(st, true) {
+		L.Pop(1)
+		L.PushNil()
+		return false
+	}
+	return true
+}
+
+// Pushes a file handle of the io library backed by st.
+// If closable is false the handle can't be closed from lua, like io.stdout.
+// Returns false, leaving a closed file handle on the stack, if the stream couldn't be opened.
+func (L *State) pushGoStream(st *goStream, closable bool) bool {
+	id := L.register(st)
+	Cmode := C.CString(st.mode())
+	defer C.free(unsafe.Pointer(Cmode))
+	Cclosable := C.int(0)
+	if closable {
+		Cclosable = 1
+	}
+	if C.clua_pushgostream(L.state(), C.uint(id), Cmode, Cclosable) == 0 {
+		L.unregister(id)
+		return false
+	}
+	return true
+}
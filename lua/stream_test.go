@@ -0,0 +1,61 @@
+package lua
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type closeRecorder struct {
+	*strings.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestPushFile(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	r := &closeRecorder{Reader: strings.NewReader("one\ntwo\nthree\n")}
+	if !L.PushFile(r) {
+		t.Fatal("Reader not pushed")
+	}
+	L.SetGlobal("input")
+
+	var out bytes.Buffer
+	if !L.PushFile(&out) {
+		t.Fatal("Writer not pushed")
+	}
+	L.SetGlobal("output")
+
+	err := L.DoString(`
+		assert(io.type(input) == "file")
+		assert(input:read("l") == "one")
+		local t = {}
+		for l in input:lines() do t[#t+1] = l end
+		assert(#t == 2 and t[2] == "three")
+		assert(input:seek("set", 4) == 4 and input:read("l") == "two")
+		assert(input:write("x") == nil)
+		assert(input:close())
+		output:write("a", 1, "\n")
+		output:close()
+	`)
+	if err != nil {
+		t.Fatalf("Error using go file handles: %v", err)
+	}
+	if !r.closed {
+		t.Fatal("Reader not closed")
+	}
+	if out.String() != "a1\n" {
+		t.Fatalf("Wrong output: %q", out.String())
+	}
+
+	if L.PushFile(42) || !L.IsNil(-1) {
+		t.Fatal("Invalid value pushed as a file")
+	}
+}
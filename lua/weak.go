@@ -0,0 +1,112 @@
+package lua
+
+import "fmt"
+
+// Registry keys of the weak tables holding the targets of WeakRefs and the go values associated with lua values
+const (
+	weakRefsKey    = "_GOLUA_WEAKREFS"
+	associationKey = "_GOLUA_ASSOCIATIONS"
+)
+
+// Pushes a new table whose keys, values or both are weak, mode is "k", "v" or "kv" like the __mode field of its metatable.
+func (L *State) NewWeakTable(mode string) {
+	if mode != "k" && mode != "v" && mode != "kv" {
+		panic(fmt.Sprintf("lua: invalid weak table mode %q", mode))
+	}
+	L.NewTable()
+	L.CreateTable(0, 1)
+	L.PushString(mode)
+	L.SetField(-2, "__mode")
+	L.SetMetaTable(-2)
+}
+
+// Pushes the weak table stored in the registry at key, creating it if needed
+func (L *State) getWeakTable(key string, mode string) {
+	L.GetField(LUA_REGISTRYINDEX, key)
+	if L.IsTable(-1) {
+		return
+	}
+	L.Pop(1)
+	L.NewWeakTable(mode)
+	L.PushValue(-1)
+	L.SetField(LUA_REGISTRYINDEX, key)
+}
+
+// A reference from go to a lua value that doesn't prevent its collection, see NewWeakRef
+type WeakRef struct {
+	L  *State
+	id int64
+}
+
+// Returns a weak reference to the value at index.
+//
+// Unlike Ref the reference doesn't keep the value alive: once lua collects it the reference becomes invalid.
+// Values that are not collectable objects (nil, booleans, numbers, strings) stay referenced until Release is called.
+func (L *State) NewWeakRef(index int) *WeakRef {
+	index = L.AbsIndex(index)
+	L.weakRefNext++
+	ref := &WeakRef{L, L.weakRefNext}
+	L.getWeakTable(weakRefsKey, "v")
+	L.PushValue(index)
+	L.RawSeti(-2, ref.id)
+	L.Pop(1)
+	return ref
+}
+
+// Pushes the referenced value and returns true, or pushes nil and returns false if it was collected or the reference released.
+func (ref *WeakRef) Push() bool {
+	L := ref.L
+	L.getWeakTable(weakRefsKey, "v")
+	L.RawGeti(-1, ref.id)
+	L.Remove(-2)
+	return !L.IsNil(-1)
+}
+
+// Returns false if the referenced value was collected or the reference released
+func (ref *WeakRef) Valid() bool {
+	ok := ref.Push()
+	ref.L.Pop(1)
+	return ok
+}
+
+// Releases the reference, that becomes invalid
+func (ref *WeakRef) Release() {
+	L := ref.L
+	L.getWeakTable(weakRefsKey, "v")
+	L.PushNil()
+	L.RawSeti(-2, ref.id)
+	L.Pop(1)
+}
+
+// Associates the go value v with the lua value at index, which must be a table, a function, a userdata or a thread.
+//
+// The association doesn't keep the lua value alive, and v is only referenced by L as long as the lua value is, so that
+// v can hold a WeakRef to the lua value without creating a cycle that is never collected. A nil v removes the association.
+func (L *State) Associate(index int, v interface{}) {
+	index = L.AbsIndex(index)
+	switch L.Type(index) {
+	case LUA_TTABLE, LUA_TFUNCTION, LUA_TUSERDATA, LUA_TTHREAD:
+	default:
+		panic(fmt.Sprintf("lua: can't associate a go value with a %s", L.Typename(int(L.Type(index)))))
+	}
+	L.getWeakTable(associationKey, "k")
+	L.PushValue(index)
+	if v == nil {
+		L.PushNil()
+	} else {
+		L.PushGoStruct(v)
+	}
+	L.RawSet(-3)
+	L.Pop(1)
+}
+
+// Returns the go value associated with the lua value at index by Associate, or nil
+func (L *State) Associated(index int) interface{} {
+	index = L.AbsIndex(index)
+	L.getWeakTable(associationKey, "k")
+	L.PushValue(index)
+	L.RawGet(-2)
+	v := L.ToGoStruct(-1)
+	L.Pop(2)
+	return v
+}
@@ -0,0 +1,46 @@
+package lua
+
+import "testing"
+
+type weakOwner struct {
+	ref *WeakRef
+}
+
+func TestWeakRef(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.OpenLibs()
+
+	L.NewWeakTable("k")
+	L.MustDoString("obj = {}")
+	L.GetGlobal("obj")
+	owner := &weakOwner{L.NewWeakRef(-1)}
+	L.Associate(-1, owner)
+	if L.Associated(-1) != owner {
+		t.Fatal("Association lost")
+	}
+	L.Pop(2)
+
+	if !owner.ref.Valid() {
+		t.Fatal("Reference invalid while the value is alive")
+	}
+	L.PushNil()
+	L.SetGlobal("obj")
+	L.CollectGarbage()
+	L.CollectGarbage()
+
+	if owner.ref.Valid() {
+		t.Fatal("Reference still valid after collection")
+	}
+	if n := L.Stats().ByType["*lua.weakOwner"]; n != 0 {
+		t.Fatalf("Associated go value still referenced: %d", n)
+	}
+
+	L.PushString("string")
+	ref := L.NewWeakRef(-1)
+	L.Pop(1)
+	ref.Release()
+	if ref.Push() || !L.IsNil(-1) {
+		t.Fatal("Released reference still valid")
+	}
+}